@@ -0,0 +1,9 @@
+package rebase
+
+import "embed"
+
+// Carries holds the fixed and additional carries shipped with the tool,
+// used as the last carries directory.
+//
+//go:embed carries
+var Carries embed.FS
@@ -34,6 +34,8 @@ func NewRootCommand() *cobra.Command {
 
 	command.AddCommand(cmd.NewCarriesCommand(streams))
 	command.AddCommand(cmd.NewApplyCommand(streams))
+	command.AddCommand(cmd.NewPlanCommand(streams))
+	command.AddCommand(cmd.NewLintCommand(streams))
 
 	logging := flag.NewFlagSet("logging", flag.ContinueOnError)
 	klog.InitFlags(logging)
@@ -1,210 +1,844 @@
 package apply
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path"
-	"regexp"
-	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/openshift/rebase/pkg/carry"
 	"github.com/openshift/rebase/pkg/git"
 	"github.com/openshift/rebase/pkg/github"
+	"github.com/openshift/rebase/pkg/profile"
+	"github.com/openshift/rebase/pkg/store"
+	"github.com/openshift/rebase/pkg/upstream"
 	"github.com/openshift/rebase/pkg/utils"
 	"k8s.io/klog/v2"
 )
 
 type Apply struct {
-	log           *carry.Log
-	from          string
-	repositoryDir string
+	log            *carry.Log
+	from           string
+	repositoryDir  string
+	profile        *profile.Profile
+	fetch          bool
+	reports        []string
+	keepGoing      bool
+	maxFailures    int
+	to             string
+	branch         string
+	existingBranch ExistingBranchPolicy
+	github         *github.Client
+	store          *store.Store
+	carriesVersion string
+	plan           string
+	out            io.Writer
+
+	resolver *upstream.Resolver
+}
+
+// Options holds the settings of an apply.
+type Options struct {
+	// From is the kubernetes version to read carries from
+	From string
+	// RepositoryDir is the kubernetes repository directory
+	RepositoryDir string
+	// Profile describes the downstream and upstream repositories
+	Profile *profile.Profile
+	// Fetch creates missing remotes and fetches them before reading carries
+	Fetch bool
+	// KeepDuplicate decides which of the carries with the same changes is kept
+	KeepDuplicate carry.DuplicatePreference
+	// Reports lists files to write the outcome of every carry to, files
+	// with .json extension are written as JSON, all others as Markdown
+	Reports []string
+	// KeepGoing records failed steps and continues with the next one, instead of stopping
+	KeepGoing bool
+	// MaxFailures is the number of failed steps after which the apply stops
+	// even when KeepGoing is set, zero means no limit
+	MaxFailures int
+	// To is the upstream tag, branch or sha to rebase onto, defaults to
+	// the upstream branch of the profile
+	To string
+	// Branch is the name of the rebase branch, defaults to rebase-YYYY-MM-DD
+	Branch string
+	// ExistingBranch decides what happens when the rebase branch already exists
+	ExistingBranch ExistingBranchPolicy
+	// GitHub is used to check picks not found in the local upstream history,
+	// nil checks the local upstream history only
+	GitHub *github.Client
+	// Store holds fixed and additional carries
+	Store *store.Store
+	// CarriesVersion is the version whose carries take precedence, defaults
+	// to the version of the target
+	CarriesVersion string
+	// Plan is the path to a plan to apply, instead of the carries
+	Plan string
 }
 
+// ExistingBranchPolicy describes what happens when the rebase branch already exists
+type ExistingBranchPolicy string
+
 const (
-	carryAction = "<carry>"
-	dropAction  = "<drop>"
-	skipPatch   = "<skip>"
+	// ExistingBranchRefuse fails the apply
+	ExistingBranchRefuse ExistingBranchPolicy = "refuse"
+	// ExistingBranchReuse applies the carries on top of the existing branch
+	ExistingBranchReuse ExistingBranchPolicy = "reuse"
+	// ExistingBranchSuffix creates a new branch, with the first free numeric suffix
+	ExistingBranchSuffix ExistingBranchPolicy = "suffix"
 )
 
-var (
-	actionRE = regexp.MustCompile(`UPSTREAM: (?P<action>[<>\w]+):`)
+// ExistingBranchPolicies lists supported existing branch policies.
+var ExistingBranchPolicies = []string{string(ExistingBranchRefuse), string(ExistingBranchReuse), string(ExistingBranchSuffix)}
+
+// ValidateExistingBranchPolicy returns an error if the policy is not supported.
+func ValidateExistingBranchPolicy(policy string) error {
+	for _, p := range ExistingBranchPolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown existing branch policy %q, supported policies: %s", policy, strings.Join(ExistingBranchPolicies, ", "))
+}
+
+const (
+	skipPatch = "<skip>"
 )
 
-func NewApply(from, repositoryDir string) *Apply {
+func NewApply(o Options, out io.Writer) *Apply {
 	return &Apply{
-		log:           carry.NewLog(from, repositoryDir),
-		from:          from,
-		repositoryDir: repositoryDir,
+		log:            carry.NewLog(o.From, o.RepositoryDir, o.Profile, o.Fetch, o.KeepDuplicate, out, ""),
+		from:           o.From,
+		repositoryDir:  o.RepositoryDir,
+		profile:        o.Profile,
+		fetch:          o.Fetch,
+		reports:        o.Reports,
+		keepGoing:      o.KeepGoing,
+		maxFailures:    o.MaxFailures,
+		to:             o.To,
+		branch:         o.Branch,
+		existingBranch: o.ExistingBranch,
+		github:         o.GitHub,
+		store:          o.Store,
+		carriesVersion: o.CarriesVersion,
+		plan:           o.Plan,
+		out:            out,
 	}
 }
 
+// Run starts a new apply, it fails if there's one already in progress.
 func (c *Apply) Run() error {
 	// this applies the steps from https://github.com/openshift/kubernetes/blob/master/REBASE.openshift.md
-	repository, err := git.OpenGit(c.repositoryDir)
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, c.fetch)
 	if err != nil {
 		return err
 	}
-	// TODO:
-	// 1. add fetching remotes
-	// 2. checkout upstream/master and print its sha
-	commits, err := c.log.GetCommits(repository)
+	state, err := loadState(repository)
 	if err != nil {
-		return fmt.Errorf("Error reading carries: %w", err)
+		return err
 	}
-	branchName := fmt.Sprintf("rebase-%s", time.Now().Format(time.DateOnly))
-	if err := repository.CreateBranch(branchName, "refs/remotes/upstream/master"); err != nil {
-		return fmt.Errorf("Error creating rebase branch: %w", err)
+	if state != nil {
+		return fmt.Errorf("Apply from %s onto %s is already in progress, use --continue, --skip or --abort", state.From, state.Branch)
+	}
+	if err := c.checkReports(); err != nil {
+		return err
+	}
+	originalHead, err := repository.CurrentBranch()
+	if err != nil {
+		return err
+	}
+	target, err := c.target(repository)
+	if err != nil {
+		return err
+	}
+	from, err := c.fromCommit(repository)
+	if err != nil {
+		return err
+	}
+	c.resolver = upstream.NewResolver(repository, c.profile, c.github, from, target)
+	c.useCarriesVersion(repository)
+	branchName, reuse, err := c.branchName(repository)
+	if err != nil {
+		return err
+	}
+	steps, err := c.loadSteps(repository)
+	if err != nil {
+		return err
+	}
+	if reuse {
+		klog.Infof("Reusing existing branch %s.", branchName)
+		if err := repository.Checkout(branchName); err != nil {
+			return fmt.Errorf("Error checking out rebase branch: %w", err)
+		}
+	} else {
+		klog.Infof("Creating branch %s from %s (%s).", branchName, c.targetName(), target)
+		if err := repository.CreateBranch(branchName, target); err != nil {
+			return fmt.Errorf("Error creating rebase branch: %w", err)
+		}
 	}
-	if err := repository.Merge("openshift/master"); err != nil {
+	if err := repository.Merge(c.profile.Downstream.Ref()); err != nil {
 		return fmt.Errorf("Error creating rebase branch: %w", err)
 	}
-	for _, c := range commits {
-		klog.V(2).Infof("Processing %s: %q", c.Hash.String(), utils.FormatMessage(c.Message))
-		action := actionFromMessage(utils.FormatMessage(c.Message))
-		if number, err := strconv.Atoi(action); err == nil {
-			merged, err := github.IsMerged(number)
+	reports, err := absolutePaths(c.reports)
+	if err != nil {
+		return err
+	}
+	state = &State{
+		From:           c.from,
+		FromCommit:     from,
+		To:             c.targetName(),
+		Target:         target,
+		CarriesVersion: c.store.Version(),
+		CarriesDirs:    c.store.Dirs(),
+		Offline:        c.github == nil,
+		Reports:        reports,
+		KeepGoing:      c.keepGoing,
+		MaxFailures:    c.maxFailures,
+		Branch:         branchName,
+		OriginalHead:   originalHead,
+		Steps:          steps,
+		Reverted:       c.revertedCarries(),
+		Duplicates:     c.duplicateCarries(),
+	}
+	return c.process(repository, state)
+}
+
+// DryRun rehearses the apply in a temporary worktree and reports what would
+// happen to every carry, leaving the repository intact.
+func (c *Apply) DryRun() error {
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, c.fetch)
+	if err != nil {
+		return err
+	}
+	target, err := c.target(repository)
+	if err != nil {
+		return err
+	}
+	from, err := c.fromCommit(repository)
+	if err != nil {
+		return err
+	}
+	if err := c.checkReports(); err != nil {
+		return err
+	}
+	klog.Infof("Rehearsing apply onto %s (%s)...", c.targetName(), target)
+	return c.inWorktree(repository, target, "rebase-dry-run-", func(worktree git.Git) error {
+		c.resolver = upstream.NewResolver(worktree, c.profile, c.github, from, target)
+		c.useCarriesVersion(repository)
+		steps, err := c.loadSteps(worktree)
+		if err != nil {
+			return err
+		}
+		if err := worktree.Merge(c.profile.Downstream.Ref()); err != nil {
+			return fmt.Errorf("Error merging %s: %w", c.profile.Downstream.Ref(), err)
+		}
+		for i := range steps {
+			outcome, err := c.processStep(worktree, steps[i])
 			if err != nil {
-				// TODO: abort only after 2-3 errors, maybe?
-				return fmt.Errorf("Failed reading merge state for %s: %q: %w", c.Hash.String(), utils.FormatMessage(c.Message), err)
+				klog.V(2).Infof("Step %s failed: %v", steps[i].Name(), err)
+				steps[i].Conflicts = conflictsFromError(err)
+				steps[i].Reason = invalidReason(err)
+				if err := abortInProgress(worktree); err != nil {
+					return err
+				}
+				outcome = OutcomeFailed
 			}
-			if merged {
-				klog.V(1).Infof("Skipping commit %s - merged upstream.", c.Hash.String())
-				continue
+			steps[i].Outcome = outcome
+		}
+		if err := c.writeReports(&State{From: c.from, To: c.targetName(), Steps: steps, Reverted: c.revertedCarries(), Duplicates: c.duplicateCarries()}); err != nil {
+			return err
+		}
+		return printDryRun(c.out, steps)
+	})
+}
+
+// inWorktree runs f in a temporary worktree checked out at target, leaving
+// the repository intact.
+func (c *Apply) inWorktree(repository git.Git, target, prefix string, f func(worktree git.Git) error) error {
+	worktreeDir, err := os.MkdirTemp("", prefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(worktreeDir)
+	klog.V(2).Infof("Using worktree %s at %s (%s)", worktreeDir, c.targetName(), target)
+	worktree, err := repository.AddWorktree(worktreeDir, target)
+	if err != nil {
+		return fmt.Errorf("Error creating worktree: %w", err)
+	}
+	defer func() {
+		if err := repository.RemoveWorktree(worktreeDir); err != nil {
+			klog.Errorf("Removing worktree %s failed: %v", worktreeDir, err)
+		}
+	}()
+	return f(worktree)
+}
+
+// targetName returns the upstream revision to rebase onto, as specified by the user.
+func (c *Apply) targetName() string {
+	if len(c.to) > 0 {
+		return c.to
+	}
+	return c.profile.Upstream.Ref()
+}
+
+// target returns the sha of the upstream commit to rebase onto.
+func (c *Apply) target(repository git.Git) (string, error) {
+	target := c.targetName()
+	sha, err := repository.ResolveRevision(target)
+	if err != nil {
+		return "", fmt.Errorf("Error resolving target %s: %w", target, err)
+	}
+	return sha, nil
+}
+
+// fromCommit returns the sha of the commit carries are read from.
+func (c *Apply) fromCommit(repository git.Git) (string, error) {
+	sha, err := repository.ResolveRevision(c.from)
+	if err != nil {
+		return "", fmt.Errorf("Error resolving starting version %s: %w", c.from, err)
+	}
+	return sha, nil
+}
+
+// useCarriesVersion makes the store prefer carries of the target version,
+// falling back to unversioned carries when the version is unknown.
+func (c *Apply) useCarriesVersion(repository git.Git) {
+	version := c.carriesVersion
+	if len(version) == 0 {
+		var err error
+		if version, err = store.RevisionVersion(repository, c.targetName()); err != nil {
+			klog.Warningf("Using unversioned carries only: %v", err)
+		}
+	}
+	c.store = c.store.ForVersion(version)
+}
+
+// branchName returns the name of the rebase branch, and information whether
+// it already exists and should be reused, according to the existing branch policy.
+func (c *Apply) branchName(repository git.Git) (string, bool, error) {
+	name := c.branch
+	if len(name) == 0 {
+		name = fmt.Sprintf("rebase-%s", time.Now().Format(time.DateOnly))
+	}
+	exists, err := repository.BranchExists(name)
+	if err != nil {
+		return "", false, err
+	}
+	if !exists {
+		return name, false, nil
+	}
+	switch c.existingBranch {
+	case ExistingBranchReuse:
+		return name, true, nil
+	case ExistingBranchSuffix:
+		for i := 2; ; i++ {
+			suffixed := fmt.Sprintf("%s-%d", name, i)
+			exists, err := repository.BranchExists(suffixed)
+			if err != nil {
+				return "", false, err
+			}
+			if !exists {
+				return suffixed, false, nil
+			}
+		}
+	}
+	return "", false, fmt.Errorf("Branch %s already exists, use --branch to pick a different name, or --existing-branch=reuse or --existing-branch=suffix", name)
+}
+
+// loadSteps returns the steps to apply, read from the plan if one was given.
+func (c *Apply) loadSteps(repository git.Git) ([]Step, error) {
+	if len(c.plan) > 0 {
+		return readPlan(repository, c.plan)
+	}
+	return c.steps(repository)
+}
+
+// steps reads the carries and additional carries, returning the list of steps to apply.
+func (c *Apply) steps(repository git.Git) ([]Step, error) {
+	commits, err := c.log.GetCommits(repository)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading carries: %w", err)
+	}
+	additionalCarries, err := c.store.AdditionalCarries()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading additional carries: %w", err)
+	}
+	var steps []Step
+	for _, c := range commits {
+		steps = append(steps, Step{Kind: CarryStep, Commit: c.Hash.String(), Subject: utils.FormatMessage(c.Message)})
+	}
+	for _, a := range additionalCarries {
+		steps = append(steps, Step{Kind: AdditionalStep, Patch: a})
+	}
+	picks := make(map[string][]int)
+	for _, c := range commits {
+		if parsed, err := carry.NewCarry(c); err == nil && parsed.Action == carry.ActionPick {
+			picks[c.Hash.String()] = parsed.UpstreamPRs
+		}
+	}
+	if err := c.resolver.Prefetch(picks); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// revertedCarries returns carries left out of the steps, since they were reverted later.
+func (c *Apply) revertedCarries() []RevertedCarry {
+	var reverted []RevertedCarry
+	for _, r := range c.log.Reverts() {
+		reverted = append(reverted, RevertedCarry{
+			Commit:  r.Carry.Hash.String(),
+			Subject: utils.FormatMessage(r.Carry.Message),
+			Revert:  r.Revert.Hash.String(),
+		})
+	}
+	return reverted
+}
+
+// duplicateCarries returns carries left out of the steps, since another carry has the same changes.
+func (c *Apply) duplicateCarries() []DuplicateCarry {
+	var duplicates []DuplicateCarry
+	for _, d := range c.log.Duplicates() {
+		for _, dropped := range d.Dropped {
+			duplicates = append(duplicates, DuplicateCarry{
+				Commit:  dropped.Hash.String(),
+				Subject: utils.FormatMessage(dropped.Message),
+				Kept:    d.Kept.Hash.String(),
+			})
+		}
+	}
+	return duplicates
+}
+
+// printDryRun prints the steps grouped by their outcome.
+func printDryRun(out io.Writer, steps []Step) error {
+	for _, group := range []struct {
+		title    string
+		outcomes []Outcome
+	}{
+		{title: "Would succeed", outcomes: []Outcome{OutcomePicked, OutcomePickedTheirs, OutcomeApplied}},
+		{title: "Would need a fixed carry", outcomes: []Outcome{OutcomeFixed, OutcomeFixed3Way}},
+		{title: "Would be skipped", outcomes: []Outcome{OutcomeMerged, OutcomeDropped, OutcomeEmptyFix, OutcomeSkipped}},
+		{title: "Would need manual work", outcomes: []Outcome{OutcomeFailed}},
+	} {
+		var matching []Step
+		for _, s := range steps {
+			for _, o := range group.outcomes {
+				if s.Outcome == o {
+					matching = append(matching, s)
+				}
 			}
-			// in all other cases we just continue to carry a patch
-			action = carryAction
 		}
-		switch action {
-		case carryAction:
-			if err := carryFlow(repository, c); err != nil {
-				// TODO: abort only after 2-3 errors, maybe?
+		if _, err := fmt.Fprintf(out, "%s (%d):\n", group.title, len(matching)); err != nil {
+			return err
+		}
+		for _, s := range matching {
+			if _, err := fmt.Fprintf(out, "  %s\t%s\t%s\n", s.Outcome, s.Name(), s.Subject); err != nil {
 				return err
 			}
-		case dropAction:
-			klog.Warningf("Skipping drop commit https://github.com/openshift/kubernetes/commit/%s", c.Hash.String())
-		default:
-			klog.Errorf("Unkown action on commit https://github.com/openshift/kubernetes/commit/%s: %s", c.Hash.String(), action)
+			for _, c := range s.Conflicts {
+				if _, err := fmt.Fprintf(out, "    conflict: %s\n", c); err != nil {
+					return err
+				}
+			}
+			if len(s.Reason) > 0 {
+				if _, err := fmt.Fprintf(out, "    reason: %s\n", s.Reason); err != nil {
+					return err
+				}
+			}
 		}
 	}
-	additionalCarries, err := findAdditionalCarries()
+	return nil
+}
+
+// Continue resumes the apply in progress, after the current step was resolved manually.
+func (c *Apply) Continue() error {
+	repository, state, err := c.resume()
 	if err != nil {
-		return fmt.Errorf("Error reading additional carries: %w", err)
+		return err
 	}
-	for _, a := range additionalCarries {
-		klog.Infof("Found additional carry %s, applying...", a)
-		if err := repository.Apply(a); err != nil {
-			if err := repository.AbortApply(); err != nil {
-				klog.Errorf("Aborting apply failed: %v", err)
+	cherryPick, apply, err := repository.InProgress()
+	if err != nil {
+		return err
+	}
+	if cherryPick {
+		if err := repository.ContinueCherryPick(); err != nil {
+			return fmt.Errorf("Error continuing cherry-pick, make sure all conflicts are resolved: %w", err)
+		}
+	}
+	if apply {
+		if err := repository.ContinueApply(); err != nil {
+			return fmt.Errorf("Error continuing apply, make sure all conflicts are resolved: %w", err)
+		}
+	}
+	klog.Infof("Step %s was resolved manually.", state.Steps[state.Current].Name())
+	state.Steps[state.Current].Outcome = OutcomeManual
+	state.Current++
+	return c.process(repository, state)
+}
+
+// Skip resumes the apply in progress, skipping the current step.
+func (c *Apply) Skip() error {
+	repository, state, err := c.resume()
+	if err != nil {
+		return err
+	}
+	if err := abortInProgress(repository); err != nil {
+		return err
+	}
+	klog.Warningf("Skipping step %s.", state.Steps[state.Current].Name())
+	state.Steps[state.Current].Outcome = OutcomeSkipped
+	state.Current++
+	return c.process(repository, state)
+}
+
+// Abort stops the apply in progress, and checks out the original branch.
+func (c *Apply) Abort() error {
+	repository, state, err := c.openState()
+	if err != nil {
+		return err
+	}
+	if err := abortInProgress(repository); err != nil {
+		return err
+	}
+	if err := repository.Checkout(state.OriginalHead); err != nil {
+		return fmt.Errorf("Error checking out %s: %w", state.OriginalHead, err)
+	}
+	klog.Infof("Aborted apply, the rebase branch %s was left intact.", state.Branch)
+	return removeState(repository)
+}
+
+// resume opens the repository and reads the state of the apply in progress,
+// which must have a step to resume from. The carries store, checking picks
+// on GitHub, keep going mode and reports are set up the way the apply was
+// started, keep going mode and reports given when resuming take precedence.
+func (c *Apply) resume() (git.Git, *State, error) {
+	repository, state, err := c.openState()
+	if err != nil {
+		return nil, nil, err
+	}
+	if state.Current < 0 || state.Current >= len(state.Steps) {
+		return nil, nil, fmt.Errorf("Apply in progress has no step to resume at %d of %d steps, use apply --abort", state.Current, len(state.Steps))
+	}
+	if len(c.reports) > 0 {
+		if state.Reports, err = absolutePaths(c.reports); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.keepGoing {
+		state.KeepGoing, state.MaxFailures = true, c.maxFailures
+	}
+	c.reports, c.keepGoing, c.maxFailures = state.Reports, state.KeepGoing, state.MaxFailures
+	if err := c.checkReports(); err != nil {
+		return nil, nil, err
+	}
+	from := state.FromCommit
+	if len(from) == 0 {
+		from = state.From
+	}
+	// the apply is resumed with the settings it was started with
+	if state.Offline {
+		c.github = nil
+	} else if c.github == nil {
+		return nil, nil, fmt.Errorf("Apply in progress checks picks on GitHub, resume it without --offline")
+	}
+	if len(state.CarriesDirs) > 0 {
+		if c.store, err = store.New(state.CarriesDirs...); err != nil {
+			return nil, nil, err
+		}
+	}
+	c.resolver = upstream.NewResolver(repository, c.profile, c.github, from, state.Target)
+	c.store = c.store.ForVersion(state.CarriesVersion)
+	return repository, state, nil
+}
+
+// openState opens the repository and reads the state of the apply in progress.
+func (c *Apply) openState() (git.Git, *State, error) {
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := loadState(repository)
+	if err != nil {
+		return nil, nil, err
+	}
+	if state == nil {
+		return nil, nil, fmt.Errorf("No apply in progress")
+	}
+	branch, err := repository.CurrentBranch()
+	if err != nil {
+		return nil, nil, err
+	}
+	if branch != state.Branch {
+		return nil, nil, fmt.Errorf("Apply in progress works on branch %s, but %s is checked out", state.Branch, branch)
+	}
+	return repository, state, nil
+}
+
+// process applies the remaining steps, persisting the state after each one of them.
+func (c *Apply) process(repository git.Git, state *State) error {
+	for state.Current < len(state.Steps) {
+		step := &state.Steps[state.Current]
+		outcome, err := c.processStep(repository, *step)
+		if err != nil {
+			step.Outcome = OutcomeFailed
+			step.Conflicts = conflictsFromError(err)
+			step.Reason = invalidReason(err)
+			if c.keepGoing && (c.maxFailures == 0 || len(failedSteps(state.Steps)) < c.maxFailures) {
+				klog.Errorf("Step %s failed, continuing: %v", step.Name(), err)
+				if err := abortInProgress(repository); err != nil {
+					return err
+				}
+				state.Current++
+				if err := saveState(repository, state); err != nil {
+					return fmt.Errorf("Error saving apply state: %w", err)
+				}
+				continue
+			}
+			if err := saveState(repository, state); err != nil {
+				klog.Errorf("Saving apply state failed: %v", err)
 			}
-			klog.Errorf("The additional fix %s stopped working  and requires manual intervention!", a)
+			if err := c.writeReports(state); err != nil {
+				klog.Errorf("Writing report failed: %v", err)
+			}
+			klog.Errorf("Resolve the problem and run apply --continue, alternatively use apply --skip or apply --abort.")
+			return err
+		}
+		step.Outcome = outcome
+		state.Current++
+		if err := saveState(repository, state); err != nil {
+			return fmt.Errorf("Error saving apply state: %w", err)
+		}
+	}
+	// the state is removed first, so a failing report does not leave a finished apply behind
+	if err := removeState(repository); err != nil {
+		return err
+	}
+	if err := c.writeReports(state); err != nil {
+		return err
+	}
+	failed := failedSteps(state.Steps)
+	if len(failed) == 0 {
+		klog.Infof("Rebase branch %s is ready.", state.Branch)
+		return nil
+	}
+	klog.Errorf("Rebase branch %s is missing %d steps, which require manual intervention:", state.Branch, len(failed))
+	names := make([]string, 0, len(failed))
+	for _, s := range failed {
+		klog.Errorf("  %s\t%s", s.Name(), s.Subject)
+		for _, c := range s.Conflicts {
+			klog.Errorf("    conflict: %s", c)
+		}
+		names = append(names, s.Name())
+	}
+	return fmt.Errorf("%d steps require manual intervention: %s", len(failed), strings.Join(names, ", "))
+}
+
+// failedSteps returns the steps which failed.
+func failedSteps(steps []Step) []Step {
+	var failed []Step
+	for _, s := range steps {
+		if s.Outcome == OutcomeFailed {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+// processStep applies a single step, returning the decision taken. Steps
+// without a planned action are planned right before being applied.
+func (c *Apply) processStep(repository git.Git, step Step) (Outcome, error) {
+	action := step.Action
+	if len(action) == 0 {
+		var err error
+		if action, err = c.planStep(repository, step); err != nil {
+			return "", err
+		}
+	}
+	if step.Kind == AdditionalStep {
+		klog.Infof("Found additional carry %s, applying...", step.Patch)
+		if err := repository.Apply(step.Patch); err != nil {
+			klog.Errorf("The additional fix %s stopped working  and requires manual intervention!", step.Patch)
+			return "", newConflictError(repository, fmt.Sprintf("Additional carry %s", step.Patch))
+		}
+		return OutcomeApplied, nil
+	}
+
+	commit, err := repository.Commit(plumbing.NewHash(step.Commit))
+	if err != nil {
+		return "", fmt.Errorf("Error reading commit %s: %w", step.Commit, err)
+	}
+	switch action {
+	case PlanPick:
+		return c.carryFlow(repository, commit)
+	case PlanFixed:
+		return c.fixedFlow(repository, commit)
+	case PlanSkipMerged:
+		return OutcomeMerged, nil
+	case PlanDrop:
+		klog.Warningf("Skipping drop commit %s", c.profile.Downstream.CommitURL(commit.Hash.String()))
+		return OutcomeDropped, nil
+	default:
+		klog.Warningf("Skipping commit %s", c.profile.Downstream.CommitURL(commit.Hash.String()))
+		return OutcomeSkipped, nil
+	}
+}
+
+// abortInProgress aborts cherry-pick or apply, if any of them is in progress.
+func abortInProgress(repository git.Git) error {
+	cherryPick, apply, err := repository.InProgress()
+	if err != nil {
+		return err
+	}
+	if cherryPick {
+		if err := repository.AbortCherryPick(); err != nil {
+			return err
+		}
+	}
+	if apply {
+		if err := repository.AbortApply(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// carryFlow implements the carry action
-func carryFlow(repository git.Git, commit *object.Commit) error {
+// carryFlow implements the carry action, on failure the repository is left
+// with the conflicts for manual resolution.
+func (c *Apply) carryFlow(repository git.Git, commit *object.Commit) (Outcome, error) {
 	klog.V(2).Infof("Initiating carry flow for %s...", commit.Hash.String())
+	fixed, err := c.store.FixedCarry(commit.Hash.String())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("Error reading fixed carry of %s: %w", commit.Hash.String(), err)
+	}
+	if fixed != nil && fixed.Action == store.ActionDrop {
+		// the carry was planned to be picked, despite the fixed carry dropping it
+		fixed = nil
+	}
 	if err := repository.CherryPick(commit.Hash.String()); err == nil {
-		return nil
+		return OutcomePicked, nil
 	}
 	klog.Infof("Encountered problems picking %s:", commit.Hash.String())
-	if err := repository.Status(); err != nil {
-		return err
-	}
+	printConflicts(repository)
 	if err := repository.AbortCherryPick(); err != nil {
-		return err
+		return "", err
 	}
-	klog.V(2).Infof("Looking for a fixed carry")
-	patch, skip, err := findFixedCarry(commit.Hash.String())
-	if err != nil {
-		// TODO: it would be nice to get the problematic files listed here
+	if fixed == nil {
 		// if the cherry-pick failed and there's no fixed carry try using:
 		// git cherry-pick --strategy=recursive --strategy-option theirs
 		if err := repository.RetryCherryPick(commit.Hash.String()); err == nil {
-			klog.Warningf("Carry https://github.com/openshift/kubernetes/commit/%s was picked auto-magically \\o/ - make sure to double check it!", commit.Hash.String())
-			return nil
+			klog.Warningf("Carry %s was picked auto-magically \\o/ - make sure to double check it!", c.profile.Downstream.CommitURL(commit.Hash.String()))
+			return OutcomePickedTheirs, nil
 		}
 		if err := repository.AbortCherryPick(); err != nil {
-			return err
+			return "", err
 		}
-		klog.Errorf("Carry https://github.com/openshift/kubernetes/commit/%s requires manual intervention!", commit.Hash.String())
-		return err
+		// pick the carry again, to leave the conflicts for manual resolution
+		if err := repository.CherryPick(commit.Hash.String()); err == nil {
+			return OutcomePicked, nil
+		}
+		klog.Errorf("Carry %s requires manual intervention!", c.profile.Downstream.CommitURL(commit.Hash.String()))
+		return "", newConflictError(repository, fmt.Sprintf("Carry %s", commit.Hash.String()))
 	}
-	if skip {
-		klog.Infof("Found skip patch %s.", patch)
-		return nil
+	if fixed.Action == store.ActionSkip {
+		klog.Infof("Found skip patch %s - %s.", fixed.Patch, fixed.Describe())
+		return OutcomeEmptyFix, nil
+	}
+	klog.Infof("Found %s, applying - %s...", fixed.Patch, fixed.Describe())
+	return c.applyFixedCarry(repository, commit, fixed.Patch)
+}
+
+// fixedFlow applies the fixed carry instead of picking the carry.
+func (c *Apply) fixedFlow(repository git.Git, commit *object.Commit) (Outcome, error) {
+	fixed, err := c.store.FixedCarry(commit.Hash.String())
+	if err != nil {
+		return "", fmt.Errorf("Error reading fixed carry of %s: %w", commit.Hash.String(), err)
+	}
+	switch fixed.Action {
+	case store.ActionSkip:
+		klog.Infof("Found skip patch %s - %s.", fixed.Patch, fixed.Describe())
+		return OutcomeEmptyFix, nil
+	case store.ActionDrop:
+		return "", fmt.Errorf("Fixed carry of %s drops it, plan it as drop instead", commit.Hash.String())
 	}
-	klog.Infof("Found %s, applying...", patch)
+	klog.Infof("Applying %s instead of %s - %s...", fixed.Patch, commit.Hash.String(), fixed.Describe())
+	return c.applyFixedCarry(repository, commit, fixed.Patch)
+}
+
+// applyFixedCarry applies the fixed carry patch of a carry commit, on failure
+// the repository is left with the conflicts for manual resolution.
+func (c *Apply) applyFixedCarry(repository git.Git, commit *object.Commit, patch string) (Outcome, error) {
 	if err := repository.Apply(patch); err != nil {
+		klog.Infof("Encountered problems applying %s:", patch)
+		printConflicts(repository)
 		if err := repository.AbortApply(); err != nil {
 			klog.Errorf("Aborting apply failed: %v", err)
 		}
-		// TODO: it would be nice to get the problematic files listed here
 		// if the apply failed, try using 3-way merge before failing
 		if err := repository.Apply3Way(patch); err == nil {
-			klog.Warningf("Current fix https://github.com/soltysh/rebase/tree/main/carries/%s was picked auto-magically \\o/ - make sure to double check it!", commit.Hash.String())
-			return nil
-		}
-		if err := repository.AbortApply(); err != nil {
-			klog.Errorf("Aborting apply failed: %v", err)
+			klog.Warningf("Current fix %s was picked auto-magically \\o/ - make sure to double check it!", c.profile.FixedCarryURL(commit.Hash.String()))
+			return OutcomeFixed3Way, nil
 		}
-		klog.Errorf("The current fix stopped working https://github.com/soltysh/rebase/tree/main/carries/%s and requires manual intervention!",
-			commit.Hash.String())
-		klog.Errorf("The original carry was https://github.com/openshift/kubernetes/commit/%s", commit.Hash.String())
-		return err
+		klog.Errorf("The current fix stopped working %s and requires manual intervention!",
+			c.profile.FixedCarryURL(commit.Hash.String()))
+		klog.Errorf("The original carry was %s", c.profile.Downstream.CommitURL(commit.Hash.String()))
+		return "", newConflictError(repository, fmt.Sprintf("Fixed carry %s", patch))
 	}
-	return nil
+	return OutcomeFixed, nil
 }
 
-// actionFromMessage parses the upstream action from commit message, returning
-// which action to take on a commit
-func actionFromMessage(message string) string {
-	matches := actionRE.FindStringSubmatch(message)
-	lastIndex := actionRE.SubexpIndex("action")
-	if lastIndex < 0 {
-		return ""
+// conflictsFromError returns conflicts carried by the error, if any.
+func conflictsFromError(err error) []git.Conflict {
+	var conflictErr *ConflictError
+	if errors.As(err, &conflictErr) {
+		return conflictErr.Conflicts
 	}
-	return matches[lastIndex]
+	return nil
 }
 
-// findFixedCarry looks for fixed carry patches. Returns path to a file containing
-// the carry, information whether to skip it or not and an error.
-func findFixedCarry(carrySha string) (string, bool, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return "", false, err
+// ConflictError informs a step requires manual intervention, listing
+// the files which failed to merge.
+type ConflictError struct {
+	// Step describes the failed step
+	Step      string
+	Conflicts []git.Conflict
+}
+
+func (e *ConflictError) Error() string {
+	if len(e.Conflicts) == 0 {
+		return fmt.Sprintf("%s requires manual intervention", e.Step)
 	}
-	carryPath := path.Join(cwd, "carries", carrySha)
-	fileInfo, err := os.Stat(carryPath)
-	if err != nil {
-		return "", false, err
+	files := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		files = append(files, c.String())
 	}
-	// empty fixed carry informs the patch was mislabeled
-	return carryPath, fileInfo.Size() == 0, nil
+	return fmt.Sprintf("%s requires manual intervention, conflicting files: %s", e.Step, strings.Join(files, ", "))
 }
 
-// findAdditionalCarries looks for additional carry patches which need to be applied.
-// Returns a list of files containing the carries and error.
-func findAdditionalCarries() ([]string, error) {
-	cwd, err := os.Getwd()
+// newConflictError returns an error listing conflicts of the current cherry-pick or apply.
+func newConflictError(repository git.Git, step string) error {
+	conflicts, err := repository.Conflicts()
 	if err != nil {
-		return nil, err
+		klog.Errorf("Listing conflicts failed: %v", err)
 	}
-	additionalPath := path.Join(cwd, "carries", "additional")
-	files, err := os.ReadDir(additionalPath)
+	for _, c := range conflicts {
+		klog.Errorf("  conflict: %s", c)
+	}
+	return &ConflictError{Step: step, Conflicts: conflicts}
+}
+
+// printConflicts prints conflicts of the current cherry-pick or apply.
+func printConflicts(repository git.Git) {
+	conflicts, err := repository.Conflicts()
 	if err != nil {
-		return nil, err
+		klog.Errorf("Listing conflicts failed: %v", err)
+		return
 	}
-	additionalCarries := []string{}
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		additionalCarries = append(additionalCarries, path.Join(additionalPath, file.Name()))
+	for _, c := range conflicts {
+		klog.Infof("  conflict: %s", c)
 	}
-	return additionalCarries, nil
 }
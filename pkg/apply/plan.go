@@ -0,0 +1,274 @@
+package apply
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/rebase/pkg/carry"
+	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/store"
+	"github.com/openshift/rebase/pkg/upstream"
+	"github.com/openshift/rebase/pkg/utils"
+)
+
+// PlanAction describes what apply does with a step, it's decided before
+// any step is applied, and can be edited in a plan.
+type PlanAction string
+
+const (
+	// PlanPick cherry-picks the carry, falling back to its fixed carry on conflicts
+	PlanPick PlanAction = "pick"
+	// PlanFixed applies the fixed carry instead of picking the carry
+	PlanFixed PlanAction = "fixed"
+	// PlanSkipMerged skips the carry, since it was merged upstream
+	PlanSkipMerged PlanAction = "skip-merged"
+	// PlanDrop drops the carry
+	PlanDrop PlanAction = "drop"
+	// PlanSkip skips the carry with an unknown action
+	PlanSkip PlanAction = "skip"
+	// PlanAdditional applies the additional carry patch
+	PlanAdditional PlanAction = "additional"
+)
+
+// errInvalidCarry informs the commit of a step is not a valid carry, its
+// subject is malformed, such steps fail unless they are skipped
+var errInvalidCarry = errors.New("not a valid carry")
+
+// invalidReason returns the reason of a step failing, since it's not a valid
+// carry, or empty string for other errors.
+func invalidReason(err error) string {
+	if errors.Is(err, errInvalidCarry) {
+		return err.Error()
+	}
+	return ""
+}
+
+// planHelp describes the plan format, appended to written plans
+const planHelp = `#
+# Commands:
+# pick <sha> = cherry-pick the carry, falling back to its fixed carry on conflicts
+# fixed <sha> = apply the fixed carry instead of picking the carry
+# skip-merged <sha> = skip the carry, it was merged upstream
+# drop <sha> = drop the carry
+# skip <sha> = skip the carry
+# additional <patch> = apply the additional carry patch
+#
+# Lines are applied from top to bottom, they can be reordered, edited or removed.
+# Anything after the sha is ignored, empty lines and lines starting with # too.
+`
+
+// Plan decides the action of every step, and prints them in the order they
+// would be applied, without changing the repository.
+func (c *Apply) Plan() error {
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, c.fetch)
+	if err != nil {
+		return err
+	}
+	target, err := c.target(repository)
+	if err != nil {
+		return err
+	}
+	from, err := c.fromCommit(repository)
+	if err != nil {
+		return err
+	}
+	// planning reads the history only, so the repository is left intact
+	c.resolver = upstream.NewResolver(repository, c.profile, c.github, from, target)
+	c.useCarriesVersion(repository)
+	steps, err := c.steps(repository)
+	if err != nil {
+		return err
+	}
+	for i := range steps {
+		if steps[i].Action, err = c.planStep(repository, steps[i]); err != nil {
+			if !errors.Is(err, errInvalidCarry) {
+				return err
+			}
+			klog.Errorf("%v", err)
+			steps[i].Action, steps[i].Reason = PlanSkip, err.Error()
+		}
+	}
+	if _, err := fmt.Fprintf(c.out, "# Rebase plan from %s onto %s (%s)\n", c.from, c.targetName(), target); err != nil {
+		return err
+	}
+	if err := writePlan(c.out, steps); err != nil {
+		return err
+	}
+	if err := writeReverted(c.out, c.revertedCarries()); err != nil {
+		return err
+	}
+	if err := writeDuplicates(c.out, c.duplicateCarries()); err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(c.out, planHelp)
+	return err
+}
+
+// planStep decides the action of a step.
+func (c *Apply) planStep(repository git.Git, step Step) (PlanAction, error) {
+	if step.Kind == AdditionalStep {
+		return PlanAdditional, nil
+	}
+	commit, err := repository.Commit(plumbing.NewHash(step.Commit))
+	if err != nil {
+		return "", fmt.Errorf("Error reading commit %s: %w", step.Commit, err)
+	}
+	klog.V(2).Infof("Processing %s: %q", commit.Hash.String(), utils.FormatMessage(commit.Message))
+	parsed, err := carry.Parse(commit.Message)
+	if err != nil {
+		return "", fmt.Errorf("Commit %s is %w, skip it with apply --skip or a plan: %v", c.profile.Downstream.CommitURL(commit.Hash.String()), errInvalidCarry, err)
+	}
+	if parsed.Action == carry.ActionPick {
+		merged, err := c.isMerged(commit.Hash.String(), parsed.UpstreamPRs)
+		if err != nil {
+			return "", fmt.Errorf("Failed reading merge state for %s: %q: %w", commit.Hash.String(), parsed.Subject, err)
+		}
+		if merged {
+			return PlanSkipMerged, nil
+		}
+		// in all other cases we just continue to carry a patch
+	}
+	if parsed.Action == carry.ActionDrop {
+		return PlanDrop, nil
+	}
+	fixed, err := c.store.FixedCarry(commit.Hash.String())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("Error reading fixed carry of %s: %w", commit.Hash.String(), err)
+	}
+	if fixed != nil {
+		switch fixed.Action {
+		case store.ActionDrop:
+			klog.Warningf("Dropping carry %s - %s", c.profile.Downstream.CommitURL(commit.Hash.String()), fixed.Describe())
+			return PlanDrop, nil
+		case store.ActionReplace:
+			klog.Infof("Replacing carry %s with %s - %s", commit.Hash.String(), fixed.Patch, fixed.Describe())
+			return PlanFixed, nil
+		}
+	}
+	return PlanPick, nil
+}
+
+// isMerged returns information whether all the upstream pull requests, picked
+// in commit sha, were merged upstream.
+func (c *Apply) isMerged(sha string, numbers []int) (bool, error) {
+	reasons := make([]string, 0, len(numbers))
+	for _, number := range numbers {
+		merged, reason, err := c.resolver.IsMerged(sha, number)
+		if err != nil {
+			return false, err
+		}
+		if !merged {
+			klog.Infof("Carrying commit %s - not merged upstream: %s.", sha, reason)
+			return false, nil
+		}
+		reasons = append(reasons, reason)
+	}
+	klog.Infof("Skipping commit %s - merged upstream: %s.", sha, strings.Join(reasons, "; "))
+	return true, nil
+}
+
+// writePlan prints steps in the plan format, the reason of a step is printed
+// as a comment preceding it.
+func writePlan(out io.Writer, steps []Step) error {
+	for _, s := range steps {
+		if len(s.Reason) > 0 {
+			if _, err := fmt.Fprintf(out, "# %s\n", s.Reason); err != nil {
+				return err
+			}
+		}
+		var err error
+		if s.Kind == AdditionalStep {
+			_, err = fmt.Fprintf(out, "%s %s\n", s.Action, s.Patch)
+		} else {
+			_, err = fmt.Fprintf(out, "%s %s %s\n", s.Action, s.Commit, s.Subject)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeReverted prints carries left out, since they were reverted later, as plan comments.
+func writeReverted(out io.Writer, reverted []RevertedCarry) error {
+	if len(reverted) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprint(out, "#\n# Reverted carries, left out along with their reverts:\n"); err != nil {
+		return err
+	}
+	for _, r := range reverted {
+		if _, err := fmt.Fprintf(out, "# %s %s (reverted by %s)\n", r.Commit, r.Subject, r.Revert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDuplicates prints carries left out, since another carry has the same changes, as plan comments.
+func writeDuplicates(out io.Writer, duplicates []DuplicateCarry) error {
+	if len(duplicates) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprint(out, "#\n# Duplicate carries, left out since another carry has the same changes:\n"); err != nil {
+		return err
+	}
+	for _, d := range duplicates {
+		if _, err := fmt.Fprintf(out, "# %s %s (same changes as %s)\n", d.Commit, d.Subject, d.Kept); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPlan reads steps from the plan file, resolving the commits in the repository.
+func readPlan(repository git.Git, path string) ([]Step, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading plan: %w", err)
+	}
+	defer file.Close()
+	var steps []Step
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, argument, _ := strings.Cut(line, " ")
+		argument = strings.TrimSpace(argument)
+		if len(argument) == 0 {
+			return nil, fmt.Errorf("%s:%d: missing argument of %s", path, lineNumber, name)
+		}
+		action := PlanAction(name)
+		switch action {
+		case PlanAdditional:
+			steps = append(steps, Step{Kind: AdditionalStep, Patch: argument, Action: action})
+		case PlanPick, PlanFixed, PlanSkipMerged, PlanDrop, PlanSkip:
+			revision, _, _ := strings.Cut(argument, " ")
+			sha, err := repository.ResolveRevision(revision)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: Error resolving %s: %w", path, lineNumber, revision, err)
+			}
+			commit, err := repository.Commit(plumbing.NewHash(sha))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: Error reading commit %s: %w", path, lineNumber, sha, err)
+			}
+			steps = append(steps, Step{Kind: CarryStep, Commit: sha, Subject: utils.FormatMessage(commit.Message), Action: action})
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown action %q", path, lineNumber, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading plan: %w", err)
+	}
+	klog.Infof("Read %d steps from plan %s.", len(steps), path)
+	return steps, nil
+}
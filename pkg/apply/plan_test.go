@@ -0,0 +1,107 @@
+package apply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/openshift/rebase/pkg/git"
+)
+
+// fakeGit resolves revisions to the commits it holds, any other call panics.
+type fakeGit struct {
+	git.Git
+	commits []*object.Commit
+}
+
+func (f *fakeGit) ResolveRevision(revision string) (string, error) {
+	for _, c := range f.commits {
+		if strings.HasPrefix(c.Hash.String(), revision) {
+			return c.Hash.String(), nil
+		}
+	}
+	return "", fmt.Errorf("unknown revision %s", revision)
+}
+
+func (f *fakeGit) Commit(hash plumbing.Hash) (*object.Commit, error) {
+	for _, c := range f.commits {
+		if c.Hash == hash {
+			return c, nil
+		}
+	}
+	return nil, plumbing.ErrObjectNotFound
+}
+
+func TestReadPlan(t *testing.T) {
+	first := strings.Repeat("1", 40)
+	second := strings.Repeat("2", 40)
+	repository := &fakeGit{commits: []*object.Commit{
+		{Hash: plumbing.NewHash(first), Message: "UPSTREAM: <carry>: first\n\nbody\n"},
+		{Hash: plumbing.NewHash(second), Message: "UPSTREAM: 123: second\n"},
+	}}
+
+	tests := []struct {
+		name     string
+		plan     string
+		expected []Step
+		err      string
+	}{
+		{
+			name: "reordered and edited",
+			plan: `# Rebase plan from v1.0.0 onto v1.1.0
+pick 2222222 UPSTREAM: 123: second
+
+fixed ` + first + ` subject is ignored
+additional carries/additional/extra.patch
+# drop ` + first + `
+`,
+			expected: []Step{
+				{Kind: CarryStep, Commit: second, Subject: "UPSTREAM: 123: second", Action: PlanPick},
+				{Kind: CarryStep, Commit: first, Subject: "UPSTREAM: <carry>: first", Action: PlanFixed},
+				{Kind: AdditionalStep, Patch: "carries/additional/extra.patch", Action: PlanAdditional},
+			},
+		},
+		{
+			name: "unknown action",
+			plan: "pick " + first + "\nsquash " + second + "\n",
+			err:  `:2: unknown action "squash"`,
+		},
+		{
+			name: "missing sha",
+			plan: "pick " + first + "\ndrop\n",
+			err:  ":2: missing argument of drop",
+		},
+		{
+			name: "unknown sha",
+			plan: "skip 3333333 UPSTREAM: <carry>: gone\n",
+			err:  ":1: Error resolving 3333333",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plan")
+			if err := os.WriteFile(path, []byte(test.plan), 0o644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			steps, err := readPlan(repository, path)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(steps, test.expected) {
+				t.Errorf("expected steps %#v, got %#v", test.expected, steps)
+			}
+		})
+	}
+}
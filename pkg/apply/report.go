@@ -0,0 +1,163 @@
+package apply
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openshift/rebase/pkg/profile"
+	"k8s.io/klog/v2"
+)
+
+// Report describes the outcome of every step of an apply.
+type Report struct {
+	From   string `json:"from"`
+	To     string `json:"to,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	Steps  []Step `json:"steps"`
+	// Reverted lists carries left out, since they were reverted later
+	Reverted []RevertedCarry `json:"reverted,omitempty"`
+	// Duplicates lists carries left out, since another carry has the same changes
+	Duplicates []DuplicateCarry `json:"duplicates,omitempty"`
+}
+
+// writeReports writes the report into every requested file.
+func (c *Apply) writeReports(state *State) error {
+	report := Report{From: state.From, To: state.To, Branch: state.Branch, Steps: state.Steps, Reverted: state.Reverted, Duplicates: state.Duplicates}
+	for _, path := range c.reports {
+		klog.V(2).Infof("Writing report to %s...", path)
+		if err := writeReport(path, report, c.profile.Downstream); err != nil {
+			return fmt.Errorf("Error writing report %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// absolutePaths returns the paths made absolute, so that they are valid when
+// the apply is resumed from another directory.
+func absolutePaths(paths []string) ([]string, error) {
+	var result []string
+	for _, path := range paths {
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error resolving %s: %w", path, err)
+		}
+		result = append(result, absolute)
+	}
+	return result, nil
+}
+
+// checkReports makes sure every requested report can be written, before any step is applied.
+func (c *Apply) checkReports() error {
+	for _, path := range c.reports {
+		if err := checkReport(path); err != nil {
+			return fmt.Errorf("Error writing report %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// checkReport makes sure the report can be written, without changing an
+// existing report nor leaving a new one behind.
+func checkReport(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		// the report does not exist yet, check its directory is writable instead
+		file, err = os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+		if err == nil {
+			defer os.Remove(file.Name())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeReport writes a report to a file, picking the format based on its extension.
+func writeReport(path string, report Report, downstream profile.Repository) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(path) == ".json" {
+		encoder := json.NewEncoder(file)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = printMarkdown(file, report, downstream)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// printMarkdown prints the report as a Markdown table, suitable for a pull request description.
+func printMarkdown(out io.Writer, report Report, downstream profile.Repository) error {
+	counts := make(map[Outcome]int)
+	var outcomes []Outcome
+	for _, s := range report.Steps {
+		if counts[s.Outcome] == 0 {
+			outcomes = append(outcomes, s.Outcome)
+		}
+		counts[s.Outcome]++
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Rebase from %s", report.From)
+	if len(report.To) > 0 {
+		fmt.Fprintf(&b, " onto %s", report.To)
+	}
+	if len(report.Branch) > 0 {
+		fmt.Fprintf(&b, " (`%s`)", report.Branch)
+	}
+	b.WriteString("\n\n")
+	for _, o := range outcomes {
+		fmt.Fprintf(&b, "- %s: %d\n", o.Description(), counts[o])
+	}
+	b.WriteString("\n| Carry | Subject | Outcome |\n|---|---|---|\n")
+	for _, s := range report.Steps {
+		carry := fmt.Sprintf("`%s`", filepath.Base(s.Patch))
+		if s.Kind == CarryStep {
+			carry = fmt.Sprintf("[%.12s](%s)", s.Commit, downstream.CommitURL(s.Commit))
+		}
+		outcome := s.Outcome.Description()
+		if len(s.Conflicts) > 0 {
+			files := make([]string, 0, len(s.Conflicts))
+			for _, c := range s.Conflicts {
+				files = append(files, fmt.Sprintf("`%s`", c))
+			}
+			outcome = fmt.Sprintf("%s: %s", outcome, strings.Join(files, ", "))
+		}
+		if len(s.Reason) > 0 {
+			outcome = fmt.Sprintf("%s: %s", outcome, markdownEscaper.Replace(s.Reason))
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", carry, markdownEscaper.Replace(s.Subject), outcome)
+	}
+	if len(report.Reverted) > 0 {
+		b.WriteString("\n### Reverted carries\n\n| Carry | Subject | Reverted by |\n|---|---|---|\n")
+		for _, r := range report.Reverted {
+			fmt.Fprintf(&b, "| [%.12s](%s) | %s | [%.12s](%s) |\n", r.Commit, downstream.CommitURL(r.Commit),
+				markdownEscaper.Replace(r.Subject), r.Revert, downstream.CommitURL(r.Revert))
+		}
+	}
+	if len(report.Duplicates) > 0 {
+		b.WriteString("\n### Duplicate carries\n\n| Carry | Subject | Same changes as |\n|---|---|---|\n")
+		for _, d := range report.Duplicates {
+			fmt.Fprintf(&b, "| [%.12s](%s) | %s | [%.12s](%s) |\n", d.Commit, downstream.CommitURL(d.Commit),
+				markdownEscaper.Replace(d.Subject), d.Kept, downstream.CommitURL(d.Kept))
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// markdownEscaper escapes characters breaking Markdown tables and rendering html tags
+var markdownEscaper = strings.NewReplacer("|", `\|`, "<", "&lt;", ">", "&gt;")
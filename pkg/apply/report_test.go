@@ -0,0 +1,87 @@
+package apply
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift/rebase/pkg/profile"
+)
+
+func TestWriteReportDuplicates(t *testing.T) {
+	kept := strings.Repeat("1", 40)
+	dropped := strings.Repeat("2", 40)
+	report := Report{
+		From:       "v1.0.0",
+		Steps:      []Step{{Kind: CarryStep, Commit: kept, Subject: "UPSTREAM: <carry>: feature", Outcome: OutcomePicked}},
+		Duplicates: []DuplicateCarry{{Commit: dropped, Subject: "UPSTREAM: <carry>: feature, again", Kept: kept}},
+	}
+	downstream := profile.Repository{Host: "github.com", Owner: "openshift", Name: "kubernetes"}
+	dir := t.TempDir()
+
+	markdownPath := filepath.Join(dir, "report.md")
+	if err := writeReport(markdownPath, report, downstream); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	markdown, err := os.ReadFile(markdownPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "### Duplicate carries\n\n| Carry | Subject | Same changes as |\n|---|---|---|\n" +
+		"| [222222222222](https://github.com/openshift/kubernetes/commit/" + dropped + ") | UPSTREAM: &lt;carry&gt;: feature, again | " +
+		"[111111111111](https://github.com/openshift/kubernetes/commit/" + kept + ") |\n"
+	if !strings.Contains(string(markdown), expected) {
+		t.Errorf("expected duplicates in the report:\n%s", markdown)
+	}
+
+	jsonPath := filepath.Join(dir, "report.json")
+	if err := writeReport(jsonPath, report, downstream); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual Report
+	if err := json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(actual.Duplicates) != 1 || actual.Duplicates[0] != report.Duplicates[0] {
+		t.Errorf("expected duplicates %v, got %v", report.Duplicates, actual.Duplicates)
+	}
+}
+
+func TestCheckReport(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.md")
+	if err := os.WriteFile(existing, []byte("previous report"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := checkReport(filepath.Join(dir, "new.md")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := checkReport(existing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := checkReport(filepath.Join(dir, "missing", "new.md")); err == nil {
+		t.Errorf("expected error for a report in a missing directory")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "existing.md" {
+		t.Errorf("expected only existing.md to be left, got %v", entries)
+	}
+	data, err := os.ReadFile(existing)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "previous report" {
+		t.Errorf("expected the existing report to be left intact, got %q", data)
+	}
+}
@@ -0,0 +1,242 @@
+package apply
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/openshift/rebase/pkg/git"
+)
+
+// stateFile is the name of the file, inside repository's .git directory,
+// holding the progress of the current apply.
+const stateFile = "rebase-apply-state.json"
+
+// StepKind describes what kind of a change a step applies
+type StepKind string
+
+const (
+	// CarryStep is a carry commit from the openshift branch
+	CarryStep StepKind = "carry"
+	// AdditionalStep is an additional carry patch
+	AdditionalStep StepKind = "additional"
+)
+
+// Outcome describes the decision taken on a step
+type Outcome string
+
+const (
+	// OutcomePicked informs the carry was cherry-picked cleanly
+	OutcomePicked Outcome = "picked"
+	// OutcomePickedTheirs informs the carry was cherry-picked with recursive strategy and theirs option
+	OutcomePickedTheirs Outcome = "picked-theirs"
+	// OutcomeFixed informs the carry was applied from a fixed carry patch
+	OutcomeFixed Outcome = "fixed"
+	// OutcomeFixed3Way informs the carry was applied from a fixed carry patch with 3-way merge
+	OutcomeFixed3Way Outcome = "fixed-3way"
+	// OutcomeApplied informs the additional carry was applied
+	OutcomeApplied Outcome = "applied"
+	// OutcomeMerged informs the carry was skipped, since it was merged upstream
+	OutcomeMerged Outcome = "merged"
+	// OutcomeDropped informs the carry was dropped
+	OutcomeDropped Outcome = "dropped"
+	// OutcomeEmptyFix informs the carry was skipped, since its fixed carry patch
+	// is empty, or its manifest entry skips it
+	OutcomeEmptyFix Outcome = "empty-fix"
+	// OutcomeSkipped informs the carry was skipped
+	OutcomeSkipped Outcome = "skipped"
+	// OutcomeManual informs the carry was resolved manually
+	OutcomeManual Outcome = "manual"
+	// OutcomeFailed informs the carry could not be applied
+	OutcomeFailed Outcome = "failed"
+)
+
+// Description returns a human readable description of the outcome
+func (o Outcome) Description() string {
+	switch o {
+	case OutcomePicked:
+		return "cherry-picked cleanly"
+	case OutcomePickedTheirs:
+		return "cherry-picked with recursive strategy and theirs option"
+	case OutcomeFixed:
+		return "applied from a fixed carry"
+	case OutcomeFixed3Way:
+		return "applied from a fixed carry with 3-way merge"
+	case OutcomeApplied:
+		return "applied additional carry"
+	case OutcomeMerged:
+		return "skipped, merged upstream"
+	case OutcomeDropped:
+		return "dropped"
+	case OutcomeEmptyFix:
+		return "skipped by the fixed carry"
+	case OutcomeSkipped:
+		return "skipped"
+	case OutcomeManual:
+		return "resolved manually"
+	case OutcomeFailed:
+		return "failed"
+	case "":
+		return "pending"
+	}
+	return string(o)
+}
+
+// Step is a single change applied during the rebase
+type Step struct {
+	Kind StepKind `json:"kind"`
+	// Commit is the sha of the carry commit
+	Commit string `json:"commit,omitempty"`
+	// Subject is the first line of the carry commit message
+	Subject string `json:"subject,omitempty"`
+	// Patch is the path to an additional carry patch
+	Patch string `json:"patch,omitempty"`
+	// Action is the action planned for the step, empty when it's decided
+	// right before the step is applied
+	Action PlanAction `json:"action,omitempty"`
+	// Outcome is the decision taken, empty when the step was not processed yet
+	Outcome Outcome `json:"outcome,omitempty"`
+	// Conflicts lists files which failed to merge, when the step failed
+	Conflicts []git.Conflict `json:"conflicts,omitempty"`
+	// Reason explains why the step is skipped, or failed, when it's not a valid carry
+	Reason string `json:"reason,omitempty"`
+}
+
+// Name returns a human readable identifier of a step
+func (s Step) Name() string {
+	if s.Kind == AdditionalStep {
+		return s.Patch
+	}
+	return s.Commit
+}
+
+// RevertedCarry is a carry left out of the steps, since it was reverted later
+type RevertedCarry struct {
+	// Commit is the sha of the reverted carry
+	Commit string `json:"commit"`
+	// Subject is the first line of the reverted carry commit message
+	Subject string `json:"subject"`
+	// Revert is the sha of the commit reverting the carry
+	Revert string `json:"revert"`
+}
+
+// DuplicateCarry is a carry left out of the steps, since another carry has the same changes
+type DuplicateCarry struct {
+	// Commit is the sha of the dropped carry
+	Commit string `json:"commit"`
+	// Subject is the first line of the dropped carry commit message
+	Subject string `json:"subject"`
+	// Kept is the sha of the carry with the same changes, which is applied
+	Kept string `json:"kept"`
+}
+
+// State is the persisted progress of an apply, allowing to resume it after
+// a manual intervention.
+type State struct {
+	// From is the kubernetes version the carries were read from
+	From string `json:"from"`
+	// FromCommit is the sha of the commit the carries were read from
+	FromCommit string `json:"fromCommit,omitempty"`
+	// To is the upstream revision the carries are applied onto
+	To string `json:"to,omitempty"`
+	// Target is the sha of the upstream commit the carries are applied onto
+	Target string `json:"target,omitempty"`
+	// CarriesVersion is the version whose carries take precedence
+	CarriesVersion string `json:"carriesVersion,omitempty"`
+	// CarriesDirs lists the absolute directories of the carries store, including
+	// the one embedded carries were extracted into
+	CarriesDirs []string `json:"carriesDirs,omitempty"`
+	// Offline informs picks are checked against the local upstream history only
+	Offline bool `json:"offline,omitempty"`
+	// Reports lists the absolute paths of the files the outcome is written to
+	Reports []string `json:"reports,omitempty"`
+	// KeepGoing informs failed steps are recorded, instead of stopping the apply
+	KeepGoing bool `json:"keepGoing,omitempty"`
+	// MaxFailures is the number of failed steps after which the apply stops, zero means no limit
+	MaxFailures int `json:"maxFailures,omitempty"`
+	// Branch is the name of the rebase branch
+	Branch string `json:"branch"`
+	// OriginalHead is the branch, or sha, checked out before the apply started
+	OriginalHead string `json:"originalHead"`
+	// Steps is the ordered list of changes to apply
+	Steps []Step `json:"steps"`
+	// Reverted lists carries left out, since they were reverted later
+	Reverted []RevertedCarry `json:"reverted,omitempty"`
+	// Duplicates lists carries left out, since another carry has the same changes
+	Duplicates []DuplicateCarry `json:"duplicates,omitempty"`
+	// Current is the index of the currently processed step
+	Current int `json:"current"`
+}
+
+// statePath returns the path to the state file of a given repository
+func statePath(repository git.Git) (string, error) {
+	gitDir, err := repository.GitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, stateFile), nil
+}
+
+// loadState reads the persisted state, returning nil when there's none
+func loadState(repository git.Git) (*State, error) {
+	path, err := statePath(repository)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// saveState persists the state, writing a temporary file first and renaming
+// it over the state file, so that the state is never left truncated.
+func saveState(repository git.Git, state *State) error {
+	path, err := statePath(repository)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), stateFile+".*")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(state); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("Error writing %s: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("Error writing %s: %w", path, err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("Error writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// removeState removes the persisted state
+func removeState(repository git.Git) error {
+	path, err := statePath(repository)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
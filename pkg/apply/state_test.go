@@ -0,0 +1,49 @@
+package apply
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// gitDirFake is a fakeGit returning the directory it holds as the git directory.
+type gitDirFake struct {
+	fakeGit
+	dir string
+}
+
+func (f *gitDirFake) GitDir() (string, error) {
+	return f.dir, nil
+}
+
+func TestSaveState(t *testing.T) {
+	repository := &gitDirFake{dir: t.TempDir()}
+	state := &State{
+		From:        "v1.0.0",
+		Reports:     []string{"/tmp/report.md"},
+		KeepGoing:   true,
+		MaxFailures: 3,
+		Branch:      "rebase-2024-01-01",
+		Steps:       []Step{{Kind: CarryStep, Commit: "1111111", Outcome: OutcomePicked}},
+		Current:     1,
+	}
+	for i := 0; i < 2; i++ {
+		if err := saveState(repository, state); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	actual, err := loadState(repository)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, state) {
+		t.Errorf("expected state %#v, got %#v", state, actual)
+	}
+	entries, err := os.ReadDir(repository.dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != stateFile {
+		t.Errorf("expected only %s to be left, got %v", stateFile, entries)
+	}
+}
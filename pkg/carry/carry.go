@@ -0,0 +1,161 @@
+package carry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Action is the UPSTREAM action of a carry commit, describing what to do with it during rebase
+type Action string
+
+const (
+	// ActionPick marks a commit picking upstream pull requests, carried until they are merged upstream
+	ActionPick Action = "pick"
+	// ActionCarry marks a commit carried only in openshift
+	ActionCarry Action = "carry"
+	// ActionDrop marks a commit which should be dropped during rebase
+	ActionDrop Action = "drop"
+)
+
+// ErrNotCarry is returned when parsing a commit message without the UPSTREAM prefix.
+var ErrNotCarry = errors.New("missing UPSTREAM prefix")
+
+var (
+	// componentRE matches the component tag, which optionally follows the action,
+	// it starts with a letter, so that a summary starting with a number is not one
+	componentRE = regexp.MustCompile(`^([a-z][a-z0-9./_-]*): (.+)$`)
+	// trailerRE matches a single line of the trailers paragraph
+	trailerRE = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*): (.*)$`)
+)
+
+// Carry is a parsed carry commit message, e.g.
+//
+//	UPSTREAM: <carry>: component: summary
+//	UPSTREAM: 123, 456: summary
+type Carry struct {
+	// Action is what to do with the carry during rebase
+	Action Action
+	// UpstreamPRs lists the picked upstream pull requests, for the pick action
+	UpstreamPRs []int
+	// Component is the optional component tag following the action
+	Component string
+	// Subject is the first line of the commit message
+	Subject string
+	// Summary is the subject without the UPSTREAM prefix, action and component
+	Summary string
+	// Body is the commit message following the subject, without the trailers
+	Body string
+	// Trailers lists the trailers closing the commit message, in their order
+	Trailers []Trailer
+	// Commit is the commit the carry was parsed from, nil when parsed from a message
+	Commit *Commit
+}
+
+// Trailer is a single "Key: value" line of the last paragraph of a commit message.
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// NewCarry parses the carry commit.
+func NewCarry(c *Commit) (*Carry, error) {
+	carry, err := Parse(c.Message)
+	if err != nil {
+		return nil, err
+	}
+	carry.Commit = c
+	return carry, nil
+}
+
+// Parse parses a carry commit message. A revert of a carry is carried downstream.
+// The UPSTREAM prefix must start the subject, it's not looked for anywhere
+// else. Picked pull requests are listed separated with commas, anything after
+// the colon following them is the summary, even when it starts with a number.
+// Returns an error wrapping ErrNotCarry when the subject is missing the UPSTREAM
+// prefix, and an error describing the problem when the subject is malformed.
+func Parse(message string) (*Carry, error) {
+	subject, body, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	subject = strings.TrimSpace(subject)
+	carry := &Carry{Subject: subject}
+	carry.Body, carry.Trailers = splitTrailers(strings.TrimSpace(body))
+
+	if matches := revertSubjectRE.FindStringSubmatch(subject); matches != nil {
+		reverted, err := Parse(matches[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid revert %q: %w", subject, err)
+		}
+		carry.Action = ActionCarry
+		carry.Component = reverted.Component
+		carry.Summary = subject
+		return carry, nil
+	}
+
+	rest, ok := strings.CutPrefix(subject, upstreamPrefix)
+	if !ok {
+		return nil, fmt.Errorf("Invalid carry subject %q: %w", subject, ErrNotCarry)
+	}
+	action, rest, ok := strings.Cut(rest, ":")
+	action, rest = strings.TrimSpace(action), strings.TrimSpace(rest)
+	if !ok {
+		return nil, fmt.Errorf("Invalid carry subject %q: missing action", subject)
+	}
+	switch action {
+	case "<carry>":
+		carry.Action = ActionCarry
+	case "<drop>":
+		carry.Action = ActionDrop
+	default:
+		if strings.HasPrefix(action, "<") {
+			return nil, fmt.Errorf("Invalid carry subject %q: unknown action %s", subject, action)
+		}
+		carry.Action = ActionPick
+		numbers, err := parseNumbers(action)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid carry subject %q: %w", subject, err)
+		}
+		carry.UpstreamPRs = numbers
+	}
+	if matches := componentRE.FindStringSubmatch(rest); matches != nil {
+		carry.Component, rest = matches[1], matches[2]
+	}
+	carry.Summary = strings.TrimSpace(rest)
+	if len(carry.Summary) == 0 {
+		return nil, fmt.Errorf("Invalid carry subject %q: missing summary", subject)
+	}
+	return carry, nil
+}
+
+// parseNumbers parses a comma separated list of upstream pull request numbers.
+func parseNumbers(list string) ([]int, error) {
+	var numbers []int
+	for _, n := range strings.Split(list, ",") {
+		n = strings.TrimPrefix(strings.TrimSpace(n), "#")
+		number, err := strconv.Atoi(n)
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf("invalid upstream pull request number %q", n)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// splitTrailers splits the body into the text and the trailers of its last
+// paragraph, when every line of the paragraph is a trailer.
+func splitTrailers(body string) (string, []Trailer) {
+	text, last := "", body
+	if i := strings.LastIndex(body, "\n\n"); i >= 0 {
+		text, last = body[:i], body[i+2:]
+	}
+	var trailers []Trailer
+	for _, line := range strings.Split(strings.TrimSpace(last), "\n") {
+		matches := trailerRE.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			return body, nil
+		}
+		trailers = append(trailers, Trailer{Key: matches[1], Value: strings.TrimSpace(matches[2])})
+	}
+	return strings.TrimSpace(text), trailers
+}
@@ -0,0 +1,123 @@
+package carry
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected *Carry
+		// err is a part of the expected error message
+		err string
+	}{
+		{
+			name:    "single pull request",
+			message: "UPSTREAM: 12345: fix the scheduler",
+			expected: &Carry{Action: ActionPick, UpstreamPRs: []int{12345},
+				Subject: "UPSTREAM: 12345: fix the scheduler", Summary: "fix the scheduler"},
+		},
+		{
+			name:    "summary starting with a number",
+			message: "UPSTREAM: 12345: 2024: fix year handling",
+			expected: &Carry{Action: ActionPick, UpstreamPRs: []int{12345},
+				Subject: "UPSTREAM: 12345: 2024: fix year handling", Summary: "2024: fix year handling"},
+		},
+		{
+			name:    "multiple pull requests",
+			message: "UPSTREAM: 123, #456,789: kubelet: fix pods",
+			expected: &Carry{Action: ActionPick, UpstreamPRs: []int{123, 456, 789}, Component: "kubelet",
+				Subject: "UPSTREAM: 123, #456,789: kubelet: fix pods", Summary: "fix pods"},
+		},
+		{
+			name:    "carry with a component",
+			message: "UPSTREAM: <carry>: openshift-apiserver: add route\n\nLonger description.\n\nSigned-off-by: Dev <dev@x>\nBug: 123\n",
+			expected: &Carry{Action: ActionCarry, Component: "openshift-apiserver",
+				Subject: "UPSTREAM: <carry>: openshift-apiserver: add route", Summary: "add route", Body: "Longer description.",
+				Trailers: []Trailer{{Key: "Signed-off-by", Value: "Dev <dev@x>"}, {Key: "Bug", Value: "123"}}},
+		},
+		{
+			name:    "carry without a component",
+			message: "UPSTREAM: <carry>: Add the openshift file",
+			expected: &Carry{Action: ActionCarry,
+				Subject: "UPSTREAM: <carry>: Add the openshift file", Summary: "Add the openshift file"},
+		},
+		{
+			name:    "drop",
+			message: "UPSTREAM: <drop>: make update\n\nRegenerated files.",
+			expected: &Carry{Action: ActionDrop,
+				Subject: "UPSTREAM: <drop>: make update", Summary: "make update", Body: "Regenerated files."},
+		},
+		{
+			name:    "revert of a carry",
+			message: "Revert \"UPSTREAM: <carry>: kubelet: add flag\"\n\nThis reverts commit 1111111.",
+			expected: &Carry{Action: ActionCarry, Component: "kubelet",
+				Subject: "Revert \"UPSTREAM: <carry>: kubelet: add flag\"", Summary: "Revert \"UPSTREAM: <carry>: kubelet: add flag\"",
+				Body: "This reverts commit 1111111."},
+		},
+		{
+			name:    "not a carry",
+			message: "Merge pull request #5 from os/feature",
+			err:     "missing UPSTREAM prefix",
+		},
+		{
+			name:    "prefix not starting the subject",
+			message: "[release-4.18] UPSTREAM: <carry>: backport",
+			err:     "missing UPSTREAM prefix",
+		},
+		{
+			name:    "revert of a commit which is not a carry",
+			message: "Revert \"fix flake\"",
+			err:     "missing UPSTREAM prefix",
+		},
+		{
+			name:    "missing action",
+			message: "UPSTREAM: 12345 fix the scheduler",
+			err:     "missing action",
+		},
+		{
+			name:    "unknown action",
+			message: "UPSTREAM: <keep>: add route",
+			err:     "unknown action <keep>",
+		},
+		{
+			name:    "invalid pull request number",
+			message: "UPSTREAM: 12a45: fix the scheduler",
+			err:     `invalid upstream pull request number "12a45"`,
+		},
+		{
+			name:    "empty pull request number",
+			message: "UPSTREAM: 123,: fix the scheduler",
+			err:     `invalid upstream pull request number ""`,
+		},
+		{
+			name:    "missing summary",
+			message: "UPSTREAM: <carry>: ",
+			err:     "missing summary",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			carry, err := Parse(test.message)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				if test.err == ErrNotCarry.Error() && !errors.Is(err, ErrNotCarry) {
+					t.Errorf("expected error wrapping ErrNotCarry, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(carry, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, carry)
+			}
+		})
+	}
+}
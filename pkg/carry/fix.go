@@ -0,0 +1,132 @@
+package carry
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/profile"
+	"github.com/openshift/rebase/pkg/store"
+	"github.com/openshift/rebase/pkg/utils"
+)
+
+// RecordFix stores a manually resolved carry as the fixed carry of the original one.
+type RecordFix struct {
+	repositoryDir  string
+	profile        *profile.Profile
+	store          *store.Store
+	version        string
+	original       string
+	commit         string
+	explicitCommit bool
+	overwrite      bool
+	out            io.Writer
+}
+
+// RecordFixOptions holds the settings of recording a fixed carry.
+type RecordFixOptions struct {
+	// RepositoryDir is the kubernetes repository directory
+	RepositoryDir string
+	// Profile describes the downstream and upstream repositories
+	Profile *profile.Profile
+	// Store holds fixed carries
+	Store *store.Store
+	// Version is the version the fixed carry is recorded for, defaults to
+	// the version of the resolved commit
+	Version string
+	// Original is the sha of the original carry commit
+	Original string
+	// Commit is the resolved commit on the rebase branch
+	Commit string
+	// ExplicitCommit informs the commit was picked by the user, so its subject
+	// is allowed to differ from the original one
+	ExplicitCommit bool
+	// Overwrite replaces an already existing fixed carry
+	Overwrite bool
+}
+
+func NewRecordFix(o RecordFixOptions, out io.Writer) *RecordFix {
+	return &RecordFix{
+		repositoryDir:  o.RepositoryDir,
+		profile:        o.Profile,
+		store:          o.Store,
+		version:        o.Version,
+		original:       o.Original,
+		commit:         o.Commit,
+		explicitCommit: o.ExplicitCommit,
+		overwrite:      o.Overwrite,
+		out:            out,
+	}
+}
+
+// Run formats the resolved commit as a patch, with the author and message of
+// the original carry, and writes it into the store.
+func (c *RecordFix) Run() error {
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, false)
+	if err != nil {
+		return err
+	}
+	original, err := repository.ResolveRevision(c.original)
+	if err != nil {
+		return fmt.Errorf("Error resolving original carry %s: %w", c.original, err)
+	}
+	resolved, err := repository.ResolveRevision(c.commit)
+	if err != nil {
+		return fmt.Errorf("Error resolving commit %s: %w", c.commit, err)
+	}
+	if original == resolved {
+		return fmt.Errorf("Commit %s is the original carry, point --commit at the resolved one", c.commit)
+	}
+	if err := c.checkSubjects(repository, original, resolved); err != nil {
+		return err
+	}
+
+	version := c.version
+	if len(version) == 0 {
+		if version, err = store.RevisionVersion(repository, resolved); err != nil {
+			klog.Warningf("Recording unversioned fixed carry: %v", err)
+		}
+	}
+
+	klog.V(2).Infof("Recording %s as the fixed carry of %s", resolved, original)
+	fixed, err := repository.CommitAs(resolved, original)
+	if err != nil {
+		return fmt.Errorf("Error creating fixed carry commit: %w", err)
+	}
+	patch, err := repository.FormatPatch(fixed)
+	if err != nil {
+		return fmt.Errorf("Error formatting fixed carry: %w", err)
+	}
+	path, err := c.store.ForVersion(version).WriteFixedCarry(original, []byte(patch), c.overwrite)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "Recorded fixed carry %s\n", path)
+	return err
+}
+
+// checkSubjects ensures the resolved commit is the original carry, unless
+// the user picked it explicitly.
+func (c *RecordFix) checkSubjects(repository git.Git, original, resolved string) error {
+	originalCommit, err := repository.Commit(plumbing.NewHash(original))
+	if err != nil {
+		return err
+	}
+	resolvedCommit, err := repository.Commit(plumbing.NewHash(resolved))
+	if err != nil {
+		return err
+	}
+	originalSubject := utils.FormatMessage(originalCommit.Message)
+	resolvedSubject := utils.FormatMessage(resolvedCommit.Message)
+	if originalSubject == resolvedSubject {
+		return nil
+	}
+	if c.explicitCommit {
+		klog.Warningf("Subject of %s %q differs from the original %q, recording with the original subject", resolved, resolvedSubject, originalSubject)
+		return nil
+	}
+	return fmt.Errorf("Subject of %s %q differs from the original %q, use --commit to point at the resolved commit", c.commit, resolvedSubject, originalSubject)
+}
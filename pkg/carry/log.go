@@ -1,37 +1,95 @@
 package carry
 
 import (
+	"errors"
 	"fmt"
-	"sort"
+	"io"
 	"strings"
-	"time"
 
+	"github.com/go-git/go-git/v5/plumbing"
 	gitv5object "github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/profile"
 	"github.com/openshift/rebase/pkg/utils"
 	"k8s.io/klog/v2"
 )
 
 const (
-	rebaseMarker   = `Merge remote-tracking branch 'openshift/master' into`
-	mergeMarker    = `Merge pull request #`
 	upstreamPrefix = "UPSTREAM: "
 )
 
 type Log struct {
 	from          string
 	repositoryDir string
+	profile       *profile.Profile
+	fetch         bool
+	keep          DuplicatePreference
+	out           io.Writer
+	output        string
+
+	// duplicates lists carries dropped by the last GetCommits, since they
+	// had the same changes as another carry
+	duplicates []Duplicate
+	// reverts lists carries dropped by the last GetCommits, along with
+	// their reverts
+	reverts []Revert
+}
+
+// DuplicatePreference decides which of the carries with the same changes is kept
+type DuplicatePreference string
+
+const (
+	// KeepFirst keeps the carry which comes first in the log
+	KeepFirst DuplicatePreference = "first"
+	// KeepLast keeps the carry which comes last in the log
+	KeepLast DuplicatePreference = "last"
+)
+
+// DuplicatePreferences lists supported duplicate preferences.
+var DuplicatePreferences = []string{string(KeepFirst), string(KeepLast)}
+
+// ValidateDuplicatePreference returns an error if the preference is not supported.
+func ValidateDuplicatePreference(keep string) error {
+	for _, p := range DuplicatePreferences {
+		if p == keep {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown duplicate preference %q, supported preferences: %s", keep, strings.Join(DuplicatePreferences, ", "))
+}
+
+// Duplicate describes carries with the same changes, of which only one is kept.
+type Duplicate struct {
+	// PatchID is the stable patch id shared by the carries
+	PatchID string
+	// Kept is the carry which is applied
+	Kept *Commit
+	// Dropped lists the other carries, in the log order
+	Dropped []*Commit
 }
 
-func NewLog(from, repositoryDir string) *Log {
+// Commit is a carry commit, along with the merge commit which brought it in.
+type Commit struct {
+	*gitv5object.Commit
+	// Merge is the merge commit which brought the carry, or nil
+	// if the carry was not read through a merge commit
+	Merge *gitv5object.Commit
+}
+
+func NewLog(from, repositoryDir string, profile *profile.Profile, fetch bool, keep DuplicatePreference, out io.Writer, output string) *Log {
 	return &Log{
 		from:          from,
 		repositoryDir: repositoryDir,
+		profile:       profile,
+		fetch:         fetch,
+		keep:          keep,
+		out:           out,
+		output:        output,
 	}
 }
 
 func (c *Log) Run() error {
-	repository, err := git.OpenGit(c.repositoryDir)
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, c.fetch)
 	if err != nil {
 		return err
 	}
@@ -39,72 +97,190 @@ func (c *Log) Run() error {
 	if err != nil {
 		return fmt.Errorf("Error reading carries: %w", err)
 	}
-	for _, c := range commits {
-		fmt.Printf("%s\t%s\t%-25s\t%s\t%s\n", c.Committer.When.Format(time.DateTime),
-			c.Author.When.Format(time.DateTime),
-			c.Author.Name, c.Hash.String(), utils.FormatMessage(c.Message))
-	}
-	return nil
+	return Print(c.out, c.output, commits)
 }
 
-func (c *Log) GetCommits(repository git.Git) ([]*gitv5object.Commit, error) {
-	if err := repository.Checkout("openshift/master"); err != nil {
-		return nil, err
+// GetCommits returns carries landed since the last rebase, in the order they
+// were merged. The last rebase is the latest merge commit, since the starting
+// version, with the rebase marker. The starting version is any revision, e.g.
+// a tag, branch or sha. Carries are read walking the first-parent
+// chain of the downstream branch down to the rebase, expanding every merge
+// into the commits it brought. Carries reverted later are left out, along
+// with their reverts.
+func (c *Log) GetCommits(repository git.Git) ([]*Commit, error) {
+	downstream := c.profile.Downstream.Ref()
+	from, err := repository.ResolveRevision(c.from)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving starting version %s: %w", c.from, err)
+	}
+	rebase, err := repository.LastMerge(downstream, from, c.profile.RebaseMarker)
+	if err != nil {
+		return nil, fmt.Errorf("Error looking for rebase marker: %w", err)
 	}
-	commits, err := repository.LogFromTag(c.from)
+	if len(rebase) == 0 {
+		return nil, fmt.Errorf("No merge with rebase marker %q found between %s and %s", c.profile.RebaseMarker, c.from, downstream)
+	}
+	klog.V(2).Infof("Found rebase marker at %s", rebase)
+	mainline, err := repository.FirstParents(downstream, rebase)
 	if err != nil {
 		return nil, err
 	}
-	sort.Sort(git.CommitsByDate(commits))
-	foundRebaseMarker := false
-	var carryCommits []*gitv5object.Commit
-	for _, c := range commits {
-		klog.V(5).Infof("Processing %s", c)
-		if !foundRebaseMarker {
-			if strings.Contains(c.Message, rebaseMarker) {
-				klog.V(2).Infof("Found rebase marker at %s", c)
-				foundRebaseMarker = true
+	var carryCommits []*Commit
+	for _, sha := range mainline {
+		commit, err := repository.Commit(plumbing.NewHash(sha))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading commit %s: %w", sha, err)
+		}
+		klog.V(5).Infof("Processing %s", commit)
+		if commit.NumParents() < 2 {
+			if isCarryOrRevert(commit) {
+				carryCommits = append(carryCommits, &Commit{Commit: commit})
 			}
 			continue
 		}
-		if strings.Contains(c.Message, mergeMarker) {
-			// TODO: check if the commit being brought by merge commit is already included, this currently produces duplicates
-			// for some of the commits, but is required since some commits might be created before the rebase landed,
-			// but merged afterwards, so the --since in log will skip them, a good example from 4.11/1.24.is:
-			// 2022-06-09 00:31:24 -0400 -0400 OpenShift Merge Robot Merge pull request #1229 from rphillips/backports/109103 cb7147853d28e94e1e32674d535e53aec4d9946f
-			// 2022-03-29 23:53:02 +0800 +0800 DingShujie UPSTREAM: 109103: cpu manager policy set to none, no one remove container id from container map, lea ed4d3f61aaccbc2fbe383c4d6b9614e8d2ad3e16
-			for _, hash := range c.ParentHashes {
-				ci, err := repository.Commit(hash)
-				if err != nil {
-					klog.Errorf("error reading commit %s: %v", hash, err)
-					continue
-				}
-				if strings.Contains(ci.Message, mergeMarker) || !strings.Contains(ci.Message, upstreamPrefix) {
-					continue
-				}
-				carryCommits = append(carryCommits, ci)
+		// the merge brings every commit of its second parent, which was not
+		// merged before, including commits created before the rebase landed
+		merged, err := repository.MergedCommits(sha, rebase)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading commits merged by %s: %w", sha, err)
+		}
+		for _, mergedSha := range merged {
+			mergedCommit, err := repository.Commit(plumbing.NewHash(mergedSha))
+			if err != nil {
+				return nil, fmt.Errorf("Error reading commit %s: %w", mergedSha, err)
 			}
+			if !isCarryOrRevert(mergedCommit) {
+				continue
+			}
+			carryCommits = append(carryCommits, &Commit{Commit: mergedCommit, Merge: commit})
+		}
+	}
+
+	carryCommits = deduplicateCommits(carryCommits)
+	carryCommits, c.reverts = cancelReverts(carryCommits)
+	for _, r := range c.reverts {
+		klog.Infof("Dropping carry %s %q, it was reverted by %s", r.Carry.Hash.String(),
+			utils.FormatMessage(r.Carry.Message), r.Revert.Hash.String())
+	}
+	shas := make([]string, 0, len(carryCommits))
+	for _, c := range carryCommits {
+		shas = append(shas, c.Hash.String())
+	}
+	patchIDs, err := repository.CommitPatchIDs(shas...)
+	if err != nil {
+		return nil, fmt.Errorf("Error computing patch ids: %w", err)
+	}
+	carryCommits, c.duplicates = deduplicatePatches(carryCommits, patchIDs, c.keep)
+	for _, d := range c.duplicates {
+		for _, dropped := range d.Dropped {
+			klog.Warningf("Dropping carry %s %q, it has the same changes as %s", dropped.Hash.String(),
+				utils.FormatMessage(dropped.Message), d.Kept.Hash.String())
+		}
+	}
+	return carryCommits, nil
+}
+
+// isCarry returns information whether the commit is meant to be a carry, its
+// subject starts with the UPSTREAM prefix, even when it's malformed, or
+// mentions the prefix elsewhere. Malformed carries are returned, so that they
+// fail to apply, instead of being silently left out. Commits mentioning the
+// prefix in their body only are not carries.
+func isCarry(commit *gitv5object.Commit) bool {
+	_, err := Parse(commit.Message)
+	return !errors.Is(err, ErrNotCarry) || strings.Contains(subject(commit.Message), strings.TrimSpace(upstreamPrefix))
+}
+
+// isCarryOrRevert returns information whether the commit is a carry, or looks
+// like a revert, warning about other commits which mention the UPSTREAM prefix
+// in their body only. It's called once for every commit read.
+func isCarryOrRevert(commit *gitv5object.Commit) bool {
+	if isCarry(commit) || isRevert(commit.Message) {
+		return true
+	}
+	if strings.Contains(commit.Message, upstreamPrefix) {
+		klog.Warningf("Skipping commit %s %q, it mentions %q in its body only, carries must start their subject with it",
+			commit.Hash.String(), utils.FormatMessage(commit.Message), upstreamPrefix)
+	}
+	return false
+}
+
+// Duplicates returns carries dropped by the last GetCommits, since they had
+// the same changes as another carry.
+func (c *Log) Duplicates() []Duplicate {
+	return c.duplicates
+}
+
+// Reverts returns carries dropped by the last GetCommits, since they were
+// reverted later, along with their reverts.
+func (c *Log) Reverts() []Revert {
+	return c.reverts
+}
+
+// RunDuplicates prints carries with the same changes, and which of them is kept.
+func (c *Log) RunDuplicates() error {
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, c.fetch)
+	if err != nil {
+		return err
+	}
+	if _, err := c.GetCommits(repository); err != nil {
+		return fmt.Errorf("Error reading carries: %w", err)
+	}
+	return PrintDuplicates(c.out, c.duplicates)
+}
+
+// deduplicatePatches drops commits with the same patch id, keeping the first
+// or the last of them, without modifying the order of the remaining commits.
+// Commits without a patch id are always kept. Returns the remaining commits,
+// and the duplicates found, ordered by the position of the kept commit.
+func deduplicatePatches(commits []*Commit, patchIDs map[string]string, keep DuplicatePreference) ([]*Commit, []Duplicate) {
+	groups := make(map[string][]*Commit)
+	for _, c := range commits {
+		if patchID, ok := patchIDs[c.Hash.String()]; ok {
+			groups[patchID] = append(groups[patchID], c)
+		}
+	}
+	var filteredCommits []*Commit
+	var duplicates []Duplicate
+	for _, c := range commits {
+		patchID, ok := patchIDs[c.Hash.String()]
+		if !ok || len(groups[patchID]) == 1 {
+			filteredCommits = append(filteredCommits, c)
 			continue
 		}
-		if !strings.Contains(c.Message, upstreamPrefix) {
+		group := groups[patchID]
+		kept := group[0]
+		if keep == KeepLast {
+			kept = group[len(group)-1]
+		}
+		if c != kept {
 			continue
 		}
-		carryCommits = append(carryCommits, c)
+		filteredCommits = append(filteredCommits, c)
+		duplicate := Duplicate{PatchID: patchID, Kept: kept}
+		for _, g := range group {
+			if g != kept {
+				duplicate.Dropped = append(duplicate.Dropped, g)
+			}
+		}
+		duplicates = append(duplicates, duplicate)
 	}
-
-	return deduplicateCommits(carryCommits), nil
+	return filteredCommits, duplicates
 }
 
 // deduplicateCommits is responsible for dropping duplicate commits from the result list,
-// but without modifying the original order of commits
-func deduplicateCommits(commits []*gitv5object.Commit) []*gitv5object.Commit {
-	uniqueSha := make(map[string]bool)
-	var filteredCommits []*gitv5object.Commit
+// but without modifying the original order of commits, the merge commit which
+// brought the carry is preserved from any of the duplicates
+func deduplicateCommits(commits []*Commit) []*Commit {
+	uniqueSha := make(map[string]*Commit)
+	var filteredCommits []*Commit
 	for _, c := range commits {
-		if _, exists := uniqueSha[c.Hash.String()]; exists {
+		if existing, exists := uniqueSha[c.Hash.String()]; exists {
+			if existing.Merge == nil {
+				existing.Merge = c.Merge
+			}
 			continue
 		}
-		uniqueSha[c.Hash.String()] = true
+		uniqueSha[c.Hash.String()] = c
 		filteredCommits = append(filteredCommits, c)
 	}
 	return filteredCommits
@@ -0,0 +1,193 @@
+package carry
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	gitv5object "github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/profile"
+)
+
+// newCommit returns a commit with the sha made of the repeated character.
+func newCommit(c byte, message string) *Commit {
+	return &Commit{Commit: &gitv5object.Commit{Hash: plumbing.NewHash(strings.Repeat(string(c), 40)), Message: message}}
+}
+
+// shas returns the first character of the sha of every commit.
+func shas(commits []*Commit) string {
+	var result string
+	for _, c := range commits {
+		result += c.Hash.String()[:1]
+	}
+	return result
+}
+
+func TestDeduplicatePatches(t *testing.T) {
+	commits := []*Commit{
+		newCommit('1', "UPSTREAM: <carry>: feature"),
+		newCommit('2', "UPSTREAM: <carry>: other"),
+		newCommit('3', "UPSTREAM: <carry>: feature, again"),
+		newCommit('4', "UPSTREAM: <carry>: empty"),
+		newCommit('5', "UPSTREAM: <carry>: feature, once more"),
+		newCommit('6', "UPSTREAM: <drop>: generated"),
+		newCommit('7', "UPSTREAM: <drop>: generated"),
+	}
+	patchIDs := map[string]string{}
+	for sha, patchID := range map[byte]string{'1': "a", '2': "b", '3': "a", '5': "a", '6': "c", '7': "c"} {
+		patchIDs[strings.Repeat(string(sha), 40)] = patchID
+	}
+
+	tests := []struct {
+		keep DuplicatePreference
+		// expected and duplicates list first characters of the shas,
+		// duplicates as the kept commit followed by the dropped ones
+		expected   string
+		duplicates []string
+	}{
+		{keep: KeepFirst, expected: "1246", duplicates: []string{"135", "67"}},
+		{keep: KeepLast, expected: "2457", duplicates: []string{"513", "76"}},
+	}
+	for _, test := range tests {
+		t.Run(string(test.keep), func(t *testing.T) {
+			filtered, duplicates := deduplicatePatches(commits, patchIDs, test.keep)
+			if actual := shas(filtered); actual != test.expected {
+				t.Errorf("expected commits %s, got %s", test.expected, actual)
+			}
+			var actual []string
+			for _, d := range duplicates {
+				actual = append(actual, shas([]*Commit{d.Kept})+shas(d.Dropped))
+			}
+			if strings.Join(actual, ",") != strings.Join(test.duplicates, ",") {
+				t.Errorf("expected duplicates %v, got %v", test.duplicates, actual)
+			}
+		})
+	}
+}
+
+// sha returns the sha made of the repeated character.
+func sha(c byte) string {
+	return strings.Repeat(string(c), 40)
+}
+
+// fakeGit serves the downstream history it holds, any other call panics.
+type fakeGit struct {
+	git.Git
+	t *testing.T
+	// from and rebase are the shas of the starting version and the rebase marker
+	from, rebase string
+	// mainline lists first parents of the downstream branch down to the rebase, oldest first
+	mainline []string
+	// merged lists commits brought by a merge, indexed by the merge sha
+	merged map[string][]string
+	// commits holds every commit, indexed by its sha
+	commits map[string]*gitv5object.Commit
+	// patchIDs holds patch ids of commits with changes, indexed by their sha
+	patchIDs map[string]string
+}
+
+func (f *fakeGit) ResolveRevision(revision string) (string, error) {
+	if revision != "v1.0.0" {
+		return "", fmt.Errorf("unknown revision %s", revision)
+	}
+	return f.from, nil
+}
+
+func (f *fakeGit) LastMerge(revision, exclude, grep string) (string, error) {
+	if revision != "openshift/master" || exclude != f.from || grep != "Merge remote-tracking branch 'openshift/master' into" {
+		f.t.Errorf("unexpected LastMerge(%s, %s, %s)", revision, exclude, grep)
+	}
+	return f.rebase, nil
+}
+
+func (f *fakeGit) FirstParents(revision, exclude string) ([]string, error) {
+	if revision != "openshift/master" || exclude != f.rebase {
+		f.t.Errorf("unexpected FirstParents(%s, %s)", revision, exclude)
+	}
+	return f.mainline, nil
+}
+
+func (f *fakeGit) MergedCommits(merge, exclude string) ([]string, error) {
+	if exclude != f.rebase {
+		f.t.Errorf("unexpected MergedCommits(%s, %s)", merge, exclude)
+	}
+	return f.merged[merge], nil
+}
+
+func (f *fakeGit) Commit(hash plumbing.Hash) (*gitv5object.Commit, error) {
+	if commit, ok := f.commits[hash.String()]; ok {
+		return commit, nil
+	}
+	return nil, plumbing.ErrObjectNotFound
+}
+
+func (f *fakeGit) CommitPatchIDs(shas ...string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, sha := range shas {
+		if patchID, ok := f.patchIDs[sha]; ok {
+			result[sha] = patchID
+		}
+	}
+	return result, nil
+}
+
+func TestGetCommits(t *testing.T) {
+	repository := &fakeGit{
+		t:        t,
+		from:     sha('f'),
+		rebase:   sha('r'),
+		mainline: []string{sha('1'), sha('a'), sha('6'), sha('b'), sha('7')},
+		merged: map[string][]string{
+			sha('a'): {sha('2'), sha('3')},
+			// the branch merged again brings the already merged carry once more
+			sha('b'): {sha('2'), sha('4')},
+		},
+		patchIDs: map[string]string{sha('1'): "p1", sha('2'): "p2", sha('4'): "p4", sha('7'): "p1"},
+		commits:  map[string]*gitv5object.Commit{},
+	}
+	for c, message := range map[byte]string{
+		'1': "UPSTREAM: <carry>: first",
+		'2': "UPSTREAM: <carry>: merged",
+		'3': "fix typo",
+		'4': "UPSTREAM: 123: merged pick",
+		'6': "bump\n\nMentions UPSTREAM: in its body only.",
+		'7': "UPSTREAM: <carry>: first, again",
+	} {
+		repository.commits[sha(c)] = &gitv5object.Commit{Hash: plumbing.NewHash(sha(c)), Message: message}
+	}
+	for _, c := range []byte{'a', 'b'} {
+		repository.commits[sha(c)] = &gitv5object.Commit{Hash: plumbing.NewHash(sha(c)),
+			Message: "Merge pull request", ParentHashes: []plumbing.Hash{plumbing.NewHash(sha('1')), plumbing.NewHash(sha('2'))}}
+	}
+	p := &profile.Profile{
+		Downstream:   profile.Repository{Remote: "openshift", Branch: "master"},
+		RebaseMarker: "Merge remote-tracking branch 'openshift/master' into",
+	}
+	log := NewLog("v1.0.0", "", p, false, KeepFirst, nil, "")
+
+	commits, err := log.GetCommits(repository)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual := shas(commits); actual != "124" {
+		t.Errorf("expected commits 124, got %s", actual)
+	}
+	var merges string
+	for _, c := range commits {
+		if c.Merge == nil {
+			merges += "-"
+			continue
+		}
+		merges += shas([]*Commit{{Commit: c.Merge}})
+	}
+	if merges != "-ab" {
+		t.Errorf("expected merges -ab, got %s", merges)
+	}
+	duplicates := log.Duplicates()
+	if len(duplicates) != 1 || shas([]*Commit{duplicates[0].Kept})+shas(duplicates[0].Dropped) != "17" {
+		t.Errorf("expected carry 7 dropped as a duplicate of 1, got %v", duplicates)
+	}
+}
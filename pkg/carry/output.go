@@ -0,0 +1,163 @@
+package carry
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/openshift/rebase/pkg/utils"
+)
+
+// OutputFormats lists supported output formats, empty format prints
+// a tab-separated line per commit.
+var OutputFormats = []string{"json", "yaml", "csv", "table"}
+
+// Record is a structured description of a carry commit.
+type Record struct {
+	CommitterDate time.Time `json:"committerDate" yaml:"committerDate"`
+	AuthorDate    time.Time `json:"authorDate" yaml:"authorDate"`
+	Author        string    `json:"author" yaml:"author"`
+	SHA           string    `json:"sha" yaml:"sha"`
+	Subject       string    `json:"subject" yaml:"subject"`
+	// Action is the parsed UPSTREAM action: carry, drop or pick
+	Action Action `json:"action" yaml:"action"`
+	// UpstreamPRs lists the numbers of the picked upstream pull requests
+	UpstreamPRs []int `json:"upstreamPRs,omitempty" yaml:"upstreamPRs,omitempty"`
+	// Component is the component tag following the action
+	Component string `json:"component,omitempty" yaml:"component,omitempty"`
+	// Error describes why the subject could not be parsed
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+	// Merge is the sha of the merge commit which brought the carry
+	Merge string `json:"merge,omitempty" yaml:"merge,omitempty"`
+}
+
+// NewRecord creates a record describing the carry commit.
+func NewRecord(c *Commit) Record {
+	subject := utils.FormatMessage(c.Message)
+	record := Record{
+		CommitterDate: c.Committer.When,
+		AuthorDate:    c.Author.When,
+		Author:        c.Author.Name,
+		SHA:           c.Hash.String(),
+		Subject:       subject,
+	}
+	if carry, err := NewCarry(c); err != nil {
+		record.Error = err.Error()
+	} else {
+		record.Action = carry.Action
+		record.UpstreamPRs = carry.UpstreamPRs
+		record.Component = carry.Component
+	}
+	if c.Merge != nil {
+		record.Merge = c.Merge.Hash.String()
+	}
+	return record
+}
+
+// Print writes carry commits to out, in a given format.
+func Print(out io.Writer, format string, commits []*Commit) error {
+	records := make([]Record, 0, len(commits))
+	for _, c := range commits {
+		records = append(records, NewRecord(c))
+	}
+	if len(format) == 0 {
+		for _, r := range records {
+			if _, err := fmt.Fprintf(out, "%s\t%s\t%-25s\t%s\t%s\n", r.CommitterDate.Format(time.DateTime),
+				r.AuthorDate.Format(time.DateTime), r.Author, r.SHA, r.Subject); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rows := make([][]string, 0, len(records))
+	for _, r := range records {
+		rows = append(rows, r.fields())
+	}
+	return PrintFormatted(out, format, records, recordHeader, rows)
+}
+
+// PrintFormatted writes value encoded as JSON or YAML, or rows along with
+// the header as CSV or a table, depending on the format, one of OutputFormats.
+func PrintFormatted(out io.Writer, format string, value interface{}, header []string, rows [][]string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "yaml":
+		encoder := yaml.NewEncoder(out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "csv":
+		writer := csv.NewWriter(out)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case "table":
+		writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.ToUpper(strings.Join(header, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	}
+	if err := ValidateOutputFormat(format); err != nil {
+		return err
+	}
+	return fmt.Errorf("output format %q is not structured", format)
+}
+
+// ValidateOutputFormat returns an error if the format is not supported.
+func ValidateOutputFormat(format string) error {
+	if len(format) == 0 {
+		return nil
+	}
+	for _, f := range OutputFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q, supported formats: %s", format, strings.Join(OutputFormats, ", "))
+}
+
+var recordHeader = []string{"committer date", "author date", "author", "sha", "subject", "action", "upstream prs", "component", "error", "merge"}
+
+// fields returns record fields in the order matching recordHeader.
+func (r Record) fields() []string {
+	upstreamPRs := make([]string, 0, len(r.UpstreamPRs))
+	for _, number := range r.UpstreamPRs {
+		upstreamPRs = append(upstreamPRs, strconv.Itoa(number))
+	}
+	return []string{r.CommitterDate.Format(time.DateTime), r.AuthorDate.Format(time.DateTime),
+		r.Author, r.SHA, r.Subject, string(r.Action), strings.Join(upstreamPRs, " "), r.Component, r.Error, r.Merge}
+}
+
+// PrintDuplicates writes carries with the same changes, and which of them is kept.
+func PrintDuplicates(out io.Writer, duplicates []Duplicate) error {
+	for _, d := range duplicates {
+		if _, err := fmt.Fprintf(out, "%s\n  kept\t%s\t%s\n", d.PatchID, d.Kept.Hash.String(), utils.FormatMessage(d.Kept.Message)); err != nil {
+			return err
+		}
+		for _, c := range d.Dropped {
+			if _, err := fmt.Fprintf(out, "  dropped\t%s\t%s\n", c.Hash.String(), utils.FormatMessage(c.Message)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package carry
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintCSV(t *testing.T) {
+	commits := []*Commit{
+		newCommit('1', "UPSTREAM: <carry>: kubelet: add flag"),
+		newCommit('2', "UPSTREAM: 123, 456: fix pods"),
+		newCommit('3', "UPSTREAM: <keep>: add route"),
+	}
+	var out bytes.Buffer
+	if err := Print(&out, "csv", commits); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	date := "0001-01-01 00:00:00"
+	expected := []string{
+		"committer date,author date,author,sha,subject,action,upstream prs,component,error,merge",
+		date + "," + date + ",," + strings.Repeat("1", 40) + ",UPSTREAM: <carry>: kubelet: add flag,carry,,kubelet,,",
+		date + "," + date + ",," + strings.Repeat("2", 40) + ",\"UPSTREAM: 123, 456: fix pods\",pick,123 456,,,",
+		date + "," + date + ",," + strings.Repeat("3", 40) + ",UPSTREAM: <keep>: add route,,,,\"Invalid carry subject \"\"UPSTREAM: <keep>: add route\"\": unknown action <keep>\",",
+	}
+	if actual := strings.TrimSuffix(out.String(), "\n"); actual != strings.Join(expected, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), actual)
+	}
+}
@@ -0,0 +1,85 @@
+package carry
+
+import (
+	"regexp"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/rebase/pkg/utils"
+)
+
+var (
+	// revertSubjectRE matches the subject git revert gives to reverts
+	revertSubjectRE = regexp.MustCompile(`^Revert "(.*)"$`)
+	// revertTrailerRE matches the line git revert adds to reverts
+	revertTrailerRE = regexp.MustCompile(`(?m)^This reverts commit ([0-9a-f]{7,40})\b`)
+)
+
+// Revert describes a carry reverted later, both of them are left out of the carries.
+type Revert struct {
+	// Carry is the reverted carry
+	Carry *Commit
+	// Revert is the commit reverting the carry
+	Revert *Commit
+}
+
+// isRevert returns information whether the commit message looks like a revert.
+func isRevert(message string) bool {
+	return revertSubjectRE.MatchString(subject(message)) || revertTrailerRE.MatchString(message)
+}
+
+// subject returns the first line of a commit message.
+func subject(message string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	return strings.TrimSpace(line)
+}
+
+// cancelReverts drops carries reverted by later commits, along with their
+// reverts, without modifying the order of the remaining commits. Reverts are
+// matched to the reverted carry by the sha from the "This reverts commit"
+// line first, and by the subject next. Reverts of commits which are not carries
+// are kept when they are carries themselves, and dropped otherwise. Returns
+// the remaining commits, and the reverts found, in the order of the reverts.
+func cancelReverts(commits []*Commit) ([]*Commit, []Revert) {
+	var filteredCommits []*Commit
+	var reverts []Revert
+	for _, c := range commits {
+		if i := revertedIndex(filteredCommits, c); i >= 0 {
+			reverts = append(reverts, Revert{Carry: filteredCommits[i], Revert: c})
+			filteredCommits = append(filteredCommits[:i:i], filteredCommits[i+1:]...)
+			continue
+		}
+		if !isCarry(c.Commit) {
+			klog.Warningf("Skipping revert %s %q, the reverted commit is not a carry since the last rebase",
+				c.Hash.String(), utils.FormatMessage(c.Message))
+			continue
+		}
+		if isRevert(c.Message) {
+			klog.V(2).Infof("Carrying revert %s %q, the reverted commit is not a carry since the last rebase",
+				c.Hash.String(), utils.FormatMessage(c.Message))
+		}
+		filteredCommits = append(filteredCommits, c)
+	}
+	return filteredCommits, reverts
+}
+
+// revertedIndex returns the index of the carry reverted by the commit, the
+// latest one when more match, or -1 if the commit does not revert any of them.
+func revertedIndex(carries []*Commit, c *Commit) int {
+	if matches := revertTrailerRE.FindStringSubmatch(c.Message); matches != nil {
+		for i := len(carries) - 1; i >= 0; i-- {
+			if strings.HasPrefix(carries[i].Hash.String(), matches[1]) {
+				return i
+			}
+		}
+	}
+	if matches := revertSubjectRE.FindStringSubmatch(subject(c.Message)); matches != nil {
+		for i := len(carries) - 1; i >= 0; i-- {
+			if subject(carries[i].Message) == matches[1] {
+				return i
+			}
+		}
+	}
+	return -1
+}
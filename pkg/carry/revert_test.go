@@ -0,0 +1,72 @@
+package carry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCancelReverts(t *testing.T) {
+	carry := newCommit('1', "UPSTREAM: <carry>: feature")
+	other := newCommit('2', "UPSTREAM: <carry>: other")
+
+	tests := []struct {
+		name    string
+		commits []*Commit
+		// expected and reverts list first characters of the shas, reverts
+		// as the reverted carry followed by the revert
+		expected string
+		reverts  []string
+	}{
+		{
+			name: "revert",
+			commits: []*Commit{carry, other,
+				newCommit('3', "Revert \"UPSTREAM: <carry>: feature\"\n\nThis reverts commit "+strings.Repeat("1", 40)+".\n")},
+			expected: "2",
+			reverts:  []string{"13"},
+		},
+		{
+			name: "revert of a revert",
+			commits: []*Commit{carry, other,
+				newCommit('3', "Revert \"UPSTREAM: <carry>: feature\"\n\nThis reverts commit "+strings.Repeat("1", 40)+".\n"),
+				newCommit('4', "Revert \"Revert \"UPSTREAM: <carry>: feature\"\"\n\nThis reverts commit "+strings.Repeat("3", 40)+".\n")},
+			expected: "24",
+			reverts:  []string{"13"},
+		},
+		{
+			name: "original outside of the range",
+			commits: []*Commit{other,
+				newCommit('3', "Revert \"UPSTREAM: <carry>: older\"\n\nThis reverts commit "+strings.Repeat("9", 40)+".\n"),
+				newCommit('4', "Revert \"fix flake\"\n\nThis reverts commit "+strings.Repeat("8", 40)+".\n")},
+			expected: "23",
+		},
+		{
+			name: "reworded subject",
+			commits: []*Commit{carry, other,
+				newCommit('3', "UPSTREAM: <carry>: stop carrying the feature\n\nThis reverts commit 1111111, it was merged upstream.\n")},
+			expected: "2",
+			reverts:  []string{"13"},
+		},
+		{
+			name: "subject of a carry picked twice",
+			commits: []*Commit{carry, other, newCommit('3', "UPSTREAM: <carry>: feature"),
+				newCommit('4', "Revert \"UPSTREAM: <carry>: feature\"")},
+			expected: "12",
+			reverts:  []string{"34"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filtered, reverts := cancelReverts(test.commits)
+			if actual := shas(filtered); actual != test.expected {
+				t.Errorf("expected commits %s, got %s", test.expected, actual)
+			}
+			var actual []string
+			for _, r := range reverts {
+				actual = append(actual, shas([]*Commit{r.Carry, r.Revert}))
+			}
+			if strings.Join(actual, ",") != strings.Join(test.reverts, ",") {
+				t.Errorf("expected reverts %v, got %v", test.reverts, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package carry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/profile"
+	"github.com/openshift/rebase/pkg/store"
+)
+
+// Verify checks fixed and additional carries kept in the store, reporting
+// the broken and orphaned ones.
+type Verify struct {
+	from          string
+	to            string
+	version       string
+	repositoryDir string
+	profile       *profile.Profile
+	store         *store.Store
+	fetch         bool
+	keep          DuplicatePreference
+	prune         bool
+	out           io.Writer
+}
+
+// VerifyOptions holds the settings of verifying the store.
+type VerifyOptions struct {
+	// From is the kubernetes version the carries are read from
+	From string
+	// To is the upstream revision the carries are checked against, defaults
+	// to the upstream branch of the profile
+	To string
+	// Version is the version whose carries are verified, along with the
+	// unversioned ones, defaults to the version of To
+	Version string
+	// RepositoryDir is the kubernetes repository directory
+	RepositoryDir string
+	// Profile describes the downstream and upstream repositories
+	Profile *profile.Profile
+	// Store holds fixed carries
+	Store *store.Store
+	// Fetch sets up and fetches the remotes
+	Fetch bool
+	// KeepDuplicate decides which of the carries with the same changes is kept
+	KeepDuplicate DuplicatePreference
+	// Prune removes orphaned carries
+	Prune bool
+}
+
+// problem describes an issue found with an entry of the store
+type problem struct {
+	// path is the file, or the manifest, the problem was found in
+	path    string
+	message string
+	// orphan informs the entry is not used anymore
+	orphan bool
+	// prunable informs the orphan can be removed by removing the path
+	prunable bool
+	// inconclusive informs the patch might apply after the carries it depends on
+	inconclusive bool
+}
+
+// patchPrefixRE matches the prefix git format-patch adds to the subject
+var patchPrefixRE = regexp.MustCompile(`^\[PATCH[^\]]*\]\s*`)
+
+func NewVerify(o VerifyOptions, out io.Writer) *Verify {
+	return &Verify{
+		from:          o.From,
+		to:            o.To,
+		version:       o.Version,
+		repositoryDir: o.RepositoryDir,
+		profile:       o.Profile,
+		store:         o.Store,
+		fetch:         o.Fetch,
+		keep:          o.KeepDuplicate,
+		prune:         o.Prune,
+		out:           out,
+	}
+}
+
+// Run checks every carry in the store, printing the problems found. Returns
+// an error when any problem, or orphan which was not pruned, was found,
+// inconclusive patches are reported only.
+func (c *Verify) Run() error {
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, c.fetch)
+	if err != nil {
+		return err
+	}
+	to := c.to
+	if len(to) == 0 {
+		to = c.profile.Upstream.Ref()
+	}
+	target, err := repository.ResolveRevision(to)
+	if err != nil {
+		return fmt.Errorf("Error resolving target %s: %w", to, err)
+	}
+	version := c.version
+	if len(version) == 0 {
+		if version, err = store.RevisionVersion(repository, to); err != nil {
+			klog.Warningf("Verifying unversioned carries only: %v", err)
+		}
+	}
+	carriesStore := c.store.ForVersion(version)
+	contents, err := carriesStore.Contents()
+	if err != nil {
+		return fmt.Errorf("Error reading carries: %w", err)
+	}
+
+	worktreeDir, err := os.MkdirTemp("", "rebase-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(worktreeDir)
+	worktree, err := repository.AddWorktree(worktreeDir, target)
+	if err != nil {
+		return fmt.Errorf("Error creating worktree: %w", err)
+	}
+	defer func() {
+		if err := repository.RemoveWorktree(worktreeDir); err != nil {
+			klog.Errorf("Removing worktree %s failed: %v", worktreeDir, err)
+		}
+	}()
+	log := NewLog(c.from, c.repositoryDir, c.profile, false, c.keep, c.out, "")
+	commits, err := log.GetCommits(worktree)
+	if err != nil {
+		return fmt.Errorf("Error reading carries: %w", err)
+	}
+	carries := make(map[string]int, len(commits))
+	for i, commit := range commits {
+		carries[commit.Hash.String()] = i
+	}
+	additionalCarries, additionalErr := carriesStore.AdditionalCarries()
+	changes := &changes{worktree: worktree, carries: commits, additional: additionalCarries}
+
+	var problems []problem
+	verified := 0
+	for _, dirContents := range contents {
+		klog.V(2).Infof("Verifying carries in %s", dirContents.Dir)
+		for _, entry := range dirContents.Fixed {
+			verified++
+			problems = append(problems, c.verifyFixed(worktree, changes, carries, version, entry)...)
+		}
+		for _, entry := range dirContents.Additional {
+			verified++
+			problems = append(problems, c.verifyAdditional(worktree, changes, version, entry)...)
+		}
+		for _, path := range dirContents.Unknown {
+			problems = append(problems, problem{path: path, message: "not a carry, nor referenced by the manifest"})
+		}
+	}
+	if additionalErr != nil {
+		problems = append(problems, problem{message: additionalErr.Error()})
+	}
+	return c.report(verified, problems)
+}
+
+// verifyFixed checks a fixed carry is still used, and its patch applies.
+// Carries are indexed by their sha, with their position among the carries.
+func (c *Verify) verifyFixed(worktree git.Git, changes *changes, carries map[string]int, version string, entry *store.Entry) []problem {
+	path := entry.Patch
+	if len(entry.Manifest) > 0 {
+		path = entry.Manifest
+	}
+	if entry.Expired(version) {
+		return []problem{{path: path, message: expiredMessage(entry), orphan: true}}
+	}
+	position, ok := carries[entry.Name]
+	if !ok {
+		return []problem{{
+			path:     path,
+			message:  fmt.Sprintf("orphaned, %s is not a carry since %s", entry.Name, c.from),
+			orphan:   true,
+			prunable: len(entry.Manifest) == 0,
+		}}
+	}
+	if entry.Action != store.ActionApply && entry.Action != store.ActionReplace {
+		return nil
+	}
+	return verifyPatch(worktree, changes, position, entry.Patch, normalizeSubject(changes.carries[position].Message))
+}
+
+// verifyAdditional checks an additional carry applies.
+func (c *Verify) verifyAdditional(worktree git.Git, changes *changes, version string, entry *store.Entry) []problem {
+	if entry.Expired(version) {
+		return []problem{{path: entry.Manifest, message: expiredMessage(entry), orphan: true}}
+	}
+	if entry.Action != store.ActionApply || len(entry.Patch) == 0 {
+		return nil
+	}
+	return verifyPatch(worktree, changes, changes.additionalPosition(entry.Patch), entry.Patch, "")
+}
+
+// expiredMessage describes the expired manifest entry.
+func expiredMessage(entry *store.Entry) string {
+	return fmt.Sprintf("%s expired in %s, remove it from the manifest", entry.Name, entry.Expires)
+}
+
+// verifyPatch checks the patch is a git format-patch mbox with the expected
+// subject, when set, which applies onto the worktree. The patch is applied
+// onto the target alone, so a patch changing files changed by carries applied
+// before it, at the position, is inconclusive rather than broken.
+func verifyPatch(worktree git.Git, changes *changes, position int, path, subject string) []problem {
+	patchSubject, err := readPatchSubject(path)
+	if err != nil {
+		return []problem{{path: path, message: err.Error()}}
+	}
+	var problems []problem
+	if len(subject) > 0 && patchSubject != subject {
+		problems = append(problems, problem{path: path, message: fmt.Sprintf("subject %q differs from the original %q", patchSubject, subject)})
+	}
+	if err := worktree.CheckApply(path); err != nil {
+		dependencies, dependenciesErr := changes.earlier(position, path)
+		switch {
+		case dependenciesErr != nil:
+			problems = append(problems, problem{path: path, message: fmt.Sprintf("does not apply: %v, reading earlier changes failed: %v", err, dependenciesErr)})
+		case len(dependencies) > 0:
+			problems = append(problems, problem{
+				path:         path,
+				message:      fmt.Sprintf("inconclusive, does not apply onto the target alone, but earlier carries change %s: %v", strings.Join(dependencies, ", "), err),
+				inconclusive: true,
+			})
+		default:
+			problems = append(problems, problem{path: path, message: fmt.Sprintf("does not apply: %v", err)})
+		}
+	}
+	return problems
+}
+
+// changes records the files changed by the carries, followed by the additional
+// carries, in the order they are applied, to tell which of them a patch
+// applied at a given position might depend on. Positions of additional
+// carries follow the positions of the carries.
+type changes struct {
+	worktree   git.Git
+	carries    []*Commit
+	additional []string
+	// first holds the position of the first change of every file, nil until read
+	first map[string]int
+}
+
+// additionalPosition returns the position of the additional carry patch,
+// after all the others when it's not applied.
+func (c *changes) additionalPosition(patch string) int {
+	for i, p := range c.additional {
+		if p == patch {
+			return len(c.carries) + i
+		}
+	}
+	return len(c.carries) + len(c.additional)
+}
+
+// earlier returns files changed by the patch, which are changed by carries
+// applied before the position.
+func (c *changes) earlier(position int, patch string) ([]string, error) {
+	if c.first == nil {
+		first := make(map[string]int)
+		record := func(position int, files []string) {
+			for _, file := range files {
+				if _, ok := first[file]; !ok {
+					first[file] = position
+				}
+			}
+		}
+		for i, commit := range c.carries {
+			files, err := c.worktree.ChangedFiles(commit.Hash.String())
+			if err != nil {
+				return nil, err
+			}
+			record(i, files)
+		}
+		for i, additional := range c.additional {
+			files, err := patchFiles(additional)
+			if err != nil {
+				return nil, err
+			}
+			record(len(c.carries)+i, files)
+		}
+		c.first = first
+	}
+	files, err := patchFiles(patch)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, file := range files {
+		if p, ok := c.first[file]; ok && p < position {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
+
+// patchFiles returns paths of the files changed by a patch.
+func patchFiles(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		var file string
+		for _, prefix := range []string{"--- a/", "+++ b/", "rename from ", "rename to "} {
+			if strings.HasPrefix(line, prefix) {
+				file = strings.TrimRight(strings.TrimPrefix(line, prefix), "\t")
+			}
+		}
+		if len(file) > 0 && !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// readPatchSubject parses a git format-patch mbox, returning its subject
+// without the [PATCH] prefix.
+func readPatchSubject(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, []byte("From ")) {
+		return "", fmt.Errorf("not a git format-patch mbox, missing From line")
+	}
+	_, headers, _ := bytes.Cut(data, []byte("\n"))
+	message, err := mail.ReadMessage(bytes.NewReader(headers))
+	if err != nil {
+		return "", fmt.Errorf("not a git format-patch mbox: %w", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		return "", fmt.Errorf("invalid subject: %w", err)
+	}
+	if len(subject) == 0 {
+		return "", fmt.Errorf("not a git format-patch mbox, missing Subject")
+	}
+	body, err := io.ReadAll(message.Body)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Contains(body, []byte("\ndiff --git ")) {
+		return "", fmt.Errorf("patch contains no changes")
+	}
+	return normalizeSubject(patchPrefixRE.ReplaceAllString(subject, "")), nil
+}
+
+// normalizeSubject returns the first paragraph of a commit message in a single
+// line, the way git format-patch puts it in the subject.
+func normalizeSubject(message string) string {
+	paragraph, _, _ := strings.Cut(strings.TrimSpace(message), "\n\n")
+	return strings.Join(strings.Fields(paragraph), " ")
+}
+
+// report prints the problems, pruning orphans if requested.
+func (c *Verify) report(verified int, problems []problem) error {
+	failed, inconclusive, orphaned, pruned := 0, 0, 0, 0
+	for _, p := range problems {
+		if p.orphan && p.prunable && c.prune {
+			if err := os.Remove(p.path); err != nil {
+				return err
+			}
+			pruned++
+			if _, err := fmt.Fprintf(c.out, "%s\tremoved, %s\n", p.path, p.message); err != nil {
+				return err
+			}
+			continue
+		}
+		switch {
+		case p.orphan:
+			orphaned++
+		case p.inconclusive:
+			inconclusive++
+		default:
+			failed++
+		}
+		if _, err := fmt.Fprintf(c.out, "%s\t%s\n", p.path, p.message); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(c.out, "Verified %d carries: %d problems, %d inconclusive, %d orphaned, %d pruned\n", verified, failed, inconclusive, orphaned, pruned); err != nil {
+		return err
+	}
+	if failed > 0 || orphaned > 0 {
+		return fmt.Errorf("Found %d problems and %d orphaned carries", failed, orphaned)
+	}
+	return nil
+}
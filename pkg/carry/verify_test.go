@@ -0,0 +1,71 @@
+package carry
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPatchFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		patch    string
+		expected []string
+	}{
+		{
+			name: "modified and added",
+			patch: `From 0000000000000000000000000000000000000000 Mon Sep 17 00:00:00 2001
+Subject: [PATCH] UPSTREAM: <carry>: feature
+
+---
+ a.txt | 2 +-
+ b.txt | 1 +
+
+diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1 +1 @@
+-a
++b
+diff --git a/b.txt b/b.txt
+new file mode 100644
+--- /dev/null
++++ b/b.txt
+@@ -0,0 +1 @@
++b
+`,
+			expected: []string{"a.txt", "b.txt"},
+		},
+		{
+			name: "removed and renamed",
+			patch: `diff --git a/c.txt b/c.txt
+deleted file mode 100644
+--- a/c.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-c
+diff --git a/d.txt b/dir/d.txt
+similarity index 100%
+rename from d.txt
+rename to dir/d.txt
+`,
+			expected: []string{"c.txt", "d.txt", "dir/d.txt"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "patch")
+			if err := os.WriteFile(path, []byte(test.patch), 0o644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			files, err := patchFiles(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(files, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v, got %v", test.expected, files)
+			}
+		})
+	}
+}
@@ -1,32 +1,143 @@
 package cmd
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/openshift/rebase/pkg/apply"
+	"github.com/openshift/rebase/pkg/carry"
+	"github.com/openshift/rebase/pkg/github"
 	"github.com/openshift/rebase/pkg/options"
 )
 
 type ApplyOptions struct {
 	options.Common
+	options.Carries
+	options.GitHub
+
+	// Continue resumes the apply in progress, after resolving the current carry manually
+	Continue bool
+	// Skip resumes the apply in progress, skipping the current carry
+	Skip bool
+	// Abort stops the apply in progress
+	Abort bool
+	// DryRun rehearses the apply in a temporary worktree
+	DryRun bool
+	// Reports lists files to write the outcome of every carry to
+	Reports []string
+	// KeepGoing continues with the next carry when one fails
+	KeepGoing bool
+	// MaxFailures stops keep going mode after the given number of failures
+	MaxFailures int
+	// To is the upstream tag, branch or sha to rebase onto
+	To string
+	// Branch is the name of the rebase branch
+	Branch string
+	// ExistingBranch decides what happens when the rebase branch already exists
+	ExistingBranch string
+	// Offline checks picks against the local upstream history only
+	Offline bool
+	// Plan is the path to a plan to apply, instead of the carries
+	Plan string
 }
 
 func NewApplyCommand(streams options.IOStreams) *cobra.Command {
-	o := &ApplyOptions{Common: options.NewCommon(streams)}
+	o := &ApplyOptions{Common: options.NewCommon(streams), Carries: options.NewCarries(), GitHub: options.NewGitHub()}
 
 	cmd := &cobra.Command{
 		Use:          "apply --repository=/go/src/k8s.io/kubernetes --from=v1.26.0",
 		Short:        "Applies carry patches from a given version of kubernetes",
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			if err := o.Common.Complete(); err != nil {
+			if err := o.Complete(); err != nil {
 				return err
 			}
-			applyAction := apply.NewApply(o.Common.From, o.Common.RepositoryDir)
+			var client *github.Client
+			if !o.Offline {
+				var err error
+				if client, err = o.GitHub.NewClient(o.Common.Profile.Upstream.Host); err != nil {
+					return err
+				}
+			}
+			carriesStore, err := o.Carries.NewStore(o.Common.Profile)
+			if err != nil {
+				return err
+			}
+			applyAction := apply.NewApply(apply.Options{
+				From:           o.Common.From,
+				RepositoryDir:  o.Common.RepositoryDir,
+				Profile:        o.Common.Profile,
+				Fetch:          o.Common.Fetch,
+				KeepDuplicate:  carry.DuplicatePreference(o.Common.KeepDuplicate),
+				Reports:        o.Reports,
+				KeepGoing:      o.KeepGoing,
+				MaxFailures:    o.MaxFailures,
+				To:             o.To,
+				Branch:         o.Branch,
+				ExistingBranch: apply.ExistingBranchPolicy(o.ExistingBranch),
+				GitHub:         client,
+				Store:          carriesStore,
+				CarriesVersion: o.Carries.Version,
+				Plan:           o.Plan,
+			}, o.Out)
+			switch {
+			case o.Continue:
+				return applyAction.Continue()
+			case o.Skip:
+				return applyAction.Skip()
+			case o.Abort:
+				return applyAction.Abort()
+			case o.DryRun:
+				return applyAction.DryRun()
+			}
 			return applyAction.Run()
 		},
 	}
-	o.Common.AddFlags(cmd.Flags())
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagsMutuallyExclusive("continue", "skip", "abort", "dry-run")
+	cmd.MarkFlagsMutuallyExclusive("continue", "skip", "abort", "plan")
 
 	return cmd
 }
+
+func (o *ApplyOptions) AddFlags(flags *pflag.FlagSet) {
+	o.Common.AddFlags(flags)
+	o.Carries.AddFlags(flags)
+	o.GitHub.AddFlags(flags)
+	flags.BoolVar(&o.Continue, "continue", o.Continue, "Continue the apply in progress, after resolving the current carry manually")
+	flags.BoolVar(&o.Skip, "skip", o.Skip, "Continue the apply in progress, skipping the current carry")
+	flags.BoolVar(&o.Abort, "abort", o.Abort, "Abort the apply in progress and check out the original branch")
+	flags.BoolVar(&o.DryRun, "dry-run", o.DryRun, "Rehearse the apply in a temporary worktree and report the outcome of every carry")
+	flags.BoolVar(&o.KeepGoing, "keep-going", o.KeepGoing, "Record carries which fail to apply and continue with the next one, instead of stopping")
+	flags.IntVar(&o.MaxFailures, "max-failures", o.MaxFailures, "Stop after the given number of failed carries, implies --keep-going, 0 means no limit")
+	flags.StringVar(&o.To, "to", o.To, "Upstream tag, branch or sha to rebase onto, defaults to the upstream branch of the profile")
+	flags.StringVar(&o.Branch, "branch", o.Branch, "Name of the rebase branch, defaults to rebase-YYYY-MM-DD")
+	flags.StringVar(&o.ExistingBranch, "existing-branch", string(apply.ExistingBranchRefuse),
+		fmt.Sprintf("What to do when the rebase branch already exists, one of: %s", strings.Join(apply.ExistingBranchPolicies, ", ")))
+	flags.BoolVar(&o.Offline, "offline", o.Offline, "Check whether picks were merged upstream using the local upstream history only, without asking GitHub")
+	flags.StringVar(&o.Plan, "plan", o.Plan, "Apply the steps of a plan written by the plan command, instead of the carries")
+	flags.StringSliceVar(&o.Reports, "report", o.Reports, "Write the outcome of every carry to a file, as JSON for .json extension and as Markdown otherwise")
+}
+
+func (o *ApplyOptions) Complete() error {
+	if o.MaxFailures < 0 {
+		return fmt.Errorf("--max-failures must not be negative")
+	}
+	if o.MaxFailures > 0 {
+		o.KeepGoing = true
+	}
+	if err := apply.ValidateExistingBranchPolicy(o.ExistingBranch); err != nil {
+		return err
+	}
+	if o.Continue || o.Skip || o.Abort {
+		// the starting version is read from the apply in progress
+		if err := o.Common.CompleteRepositoryDir(); err != nil {
+			return err
+		}
+		return o.Common.CompleteProfile()
+	}
+	return o.Common.Complete()
+}
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/openshift/rebase/pkg/carry"
 	"github.com/openshift/rebase/pkg/options"
@@ -9,6 +13,11 @@ import (
 
 type CarriesOptions struct {
 	options.Common
+
+	// Output is the output format
+	Output string
+	// Duplicates prints carries with the same changes instead of the carries
+	Duplicates bool
 }
 
 func NewCarriesCommand(streams options.IOStreams) *cobra.Command {
@@ -19,14 +28,35 @@ func NewCarriesCommand(streams options.IOStreams) *cobra.Command {
 		Short:        "Generates log of carry patches from a given version of kubernetes",
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			if err := o.Common.Complete(); err != nil {
+			if err := o.Complete(); err != nil {
 				return err
 			}
-			carriesAction := carry.NewLog(o.Common.From, o.Common.RepositoryDir)
+			carriesAction := carry.NewLog(o.Common.From, o.Common.RepositoryDir, o.Common.Profile, o.Common.Fetch,
+				carry.DuplicatePreference(o.Common.KeepDuplicate), o.Out, o.Output)
+			if o.Duplicates {
+				return carriesAction.RunDuplicates()
+			}
 			return carriesAction.Run()
 		},
 	}
-	o.Common.AddFlags(cmd.Flags())
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagsMutuallyExclusive("output", "duplicates")
+	cmd.AddCommand(NewRecordCommand(streams))
+	cmd.AddCommand(NewPromoteCommand(streams))
+	cmd.AddCommand(NewVerifyCommand(streams))
 
 	return cmd
 }
+
+func (o *CarriesOptions) AddFlags(flags *pflag.FlagSet) {
+	o.Common.AddFlags(flags)
+	flags.StringVarP(&o.Output, "output", "o", o.Output, fmt.Sprintf("Output format, one of: %s", strings.Join(carry.OutputFormats, "|")))
+	flags.BoolVar(&o.Duplicates, "duplicates", o.Duplicates, "Print carries with the same changes, and which of them is kept, instead of the carries")
+}
+
+func (o *CarriesOptions) Complete() error {
+	if err := carry.ValidateOutputFormat(o.Output); err != nil {
+		return err
+	}
+	return o.Common.Complete()
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/openshift/rebase/pkg/carry"
+	"github.com/openshift/rebase/pkg/github"
+	"github.com/openshift/rebase/pkg/lint"
+	"github.com/openshift/rebase/pkg/options"
+)
+
+type LintOptions struct {
+	options.Common
+	options.GitHub
+
+	// Offline checks picked pull requests against the local upstream history only
+	Offline bool
+	// AllowUnverified reports picked pull requests which could not be verified without failing
+	AllowUnverified bool
+	// Output is the output format
+	Output string
+}
+
+func NewLintCommand(streams options.IOStreams) *cobra.Command {
+	o := &LintOptions{Common: options.NewCommon(streams), GitHub: options.NewGitHub()}
+
+	cmd := &cobra.Command{
+		Use:   "lint [<revision-range>]",
+		Short: "Checks commits follow the UPSTREAM commit conventions",
+		Long: "Checks commits in the revision range, defaulting to the commits on top of the downstream branch, " +
+			"a single revision checks the commits since the downstream branch. Commits must have a valid UPSTREAM prefix, " +
+			"picked pull requests must exist upstream, vendored code must not be changed by a <carry>, staging code must not be " +
+			"changed by a <drop>, which changes generated files only, and a <carry> or a pick must not mix generated and hand-written changes, " +
+			"where go.sum changed along with its go.mod counts as hand-written. Picked pull requests which could not be verified, " +
+			"since GitHub is disabled with --offline or unreachable, fail the check unless --allow-unverified is set.",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(); err != nil {
+				return err
+			}
+			var client *github.Client
+			if !o.Offline {
+				var err error
+				if client, err = o.GitHub.NewClient(o.Common.Profile.Upstream.Host); err != nil {
+					return err
+				}
+			}
+			revisionRange := ""
+			if len(args) > 0 {
+				revisionRange = args[0]
+			}
+			lintAction := lint.NewLint(lint.Options{
+				RevisionRange:   revisionRange,
+				RepositoryDir:   o.Common.RepositoryDir,
+				Profile:         o.Common.Profile,
+				GitHub:          client,
+				Fetch:           o.Common.Fetch,
+				AllowUnverified: o.AllowUnverified,
+				Output:          o.Output,
+			}, o.Out)
+			return lintAction.Run()
+		},
+	}
+	o.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *LintOptions) AddFlags(flags *pflag.FlagSet) {
+	o.Common.AddRepositoryFlags(flags)
+	o.GitHub.AddFlags(flags)
+	o.Common.AddFetchFlags(flags)
+	flags.BoolVar(&o.Offline, "offline", o.Offline, "Check whether picked pull requests exist using the local upstream history only, without asking GitHub")
+	flags.BoolVar(&o.AllowUnverified, "allow-unverified", o.AllowUnverified, "Report picked pull requests which could not be verified on GitHub without failing")
+	flags.StringVarP(&o.Output, "output", "o", o.Output, fmt.Sprintf("Output format, one of: %s, prints text when empty", strings.Join(carry.OutputFormats, "|")))
+}
+
+func (o *LintOptions) Complete() error {
+	if err := o.Common.CompleteRepositoryDir(); err != nil {
+		return err
+	}
+	if err := o.Common.CompleteProfile(); err != nil {
+		return err
+	}
+	return carry.ValidateOutputFormat(o.Output)
+}
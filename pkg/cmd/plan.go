@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/openshift/rebase/pkg/apply"
+	"github.com/openshift/rebase/pkg/carry"
+	"github.com/openshift/rebase/pkg/github"
+	"github.com/openshift/rebase/pkg/options"
+)
+
+type PlanOptions struct {
+	options.Common
+	options.Carries
+	options.GitHub
+
+	// To is the upstream tag, branch or sha to rebase onto
+	To string
+	// Offline checks picks against the local upstream history only
+	Offline bool
+}
+
+func NewPlanCommand(streams options.IOStreams) *cobra.Command {
+	o := &PlanOptions{Common: options.NewCommon(streams), Carries: options.NewCarries(), GitHub: options.NewGitHub()}
+
+	cmd := &cobra.Command{
+		Use:          "plan --repository=/go/src/k8s.io/kubernetes --from=v1.26.0",
+		Short:        "Writes the steps apply would take, as a plan which can be edited and applied with apply --plan",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Common.Complete(); err != nil {
+				return err
+			}
+			var client *github.Client
+			if !o.Offline {
+				var err error
+				if client, err = o.GitHub.NewClient(o.Common.Profile.Upstream.Host); err != nil {
+					return err
+				}
+			}
+			carriesStore, err := o.Carries.NewStore(o.Common.Profile)
+			if err != nil {
+				return err
+			}
+			planAction := apply.NewApply(apply.Options{
+				From:           o.Common.From,
+				RepositoryDir:  o.Common.RepositoryDir,
+				Profile:        o.Common.Profile,
+				Fetch:          o.Common.Fetch,
+				KeepDuplicate:  carry.DuplicatePreference(o.Common.KeepDuplicate),
+				To:             o.To,
+				GitHub:         client,
+				Store:          carriesStore,
+				CarriesVersion: o.Carries.Version,
+			}, o.Out)
+			return planAction.Plan()
+		},
+	}
+	o.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *PlanOptions) AddFlags(flags *pflag.FlagSet) {
+	o.Common.AddFlags(flags)
+	o.Carries.AddFlags(flags)
+	o.GitHub.AddFlags(flags)
+	flags.StringVar(&o.To, "to", o.To, "Upstream tag, branch or sha to rebase onto, defaults to the upstream branch of the profile")
+	flags.BoolVar(&o.Offline, "offline", o.Offline, "Check whether picks were merged upstream using the local upstream history only, without asking GitHub")
+}
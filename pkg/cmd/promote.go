@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/openshift/rebase/pkg/options"
+	"github.com/openshift/rebase/pkg/store"
+)
+
+// unversioned names the unversioned carries, when used as the source version
+const unversioned = "unversioned"
+
+type PromoteOptions struct {
+	options.Common
+	options.Carries
+
+	// Keep leaves the promoted carries in the source version
+	Keep bool
+	// Overwrite replaces fixed carries already existing in the target version
+	Overwrite bool
+}
+
+func NewPromoteCommand(streams options.IOStreams) *cobra.Command {
+	o := &PromoteOptions{Common: options.NewCommon(streams), Carries: options.NewCarries()}
+
+	cmd := &cobra.Command{
+		Use:   "promote <from-version> <to-version> [<sha>...]",
+		Short: "Moves, or copies, fixed carries of one kubernetes version forward to the next one",
+		Long: fmt.Sprintf("Moves, or copies, fixed carries of one kubernetes version forward to the next one, along with their manifest entries, "+
+			"use %q as from-version to promote unversioned carries", unversioned),
+		Args:         cobra.MinimumNArgs(2),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Common.CompleteProfile(); err != nil {
+				return err
+			}
+			from := ""
+			if args[0] != unversioned {
+				var ok bool
+				if from, ok = store.Version(args[0]); !ok {
+					return fmt.Errorf("%q is not a kubernetes version", args[0])
+				}
+			}
+			to, ok := store.Version(args[1])
+			if !ok {
+				return fmt.Errorf("%q is not a kubernetes version", args[1])
+			}
+			// only the first directory is ever written to
+			o.Carries.Embedded = false
+			carriesStore, err := o.Carries.NewStore(o.Common.Profile)
+			if err != nil {
+				return err
+			}
+			promoted, err := carriesStore.Promote(from, to, args[2:], o.Keep, o.Overwrite)
+			for _, sha := range promoted {
+				fmt.Fprintf(o.Out, "%s\n", sha)
+			}
+			return err
+		},
+	}
+	o.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *PromoteOptions) AddFlags(flags *pflag.FlagSet) {
+	o.Common.AddProfileFlags(flags)
+	flags.StringSliceVar(&o.Carries.Dirs, "carries-dir", o.Carries.Dirs, "Directory holding fixed carries, only the first one is used, "+
+		"defaults to carriesDirs from the profile or "+store.DefaultDir+" in the current directory")
+	flags.BoolVar(&o.Keep, "keep", o.Keep, "Copy the carries, leaving them in the source version")
+	flags.BoolVar(&o.Overwrite, "overwrite", o.Overwrite, "Replace fixed carries already existing in the target version")
+}
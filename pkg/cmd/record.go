@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/openshift/rebase/pkg/carry"
+	"github.com/openshift/rebase/pkg/options"
+)
+
+type RecordOptions struct {
+	options.Common
+	options.Carries
+
+	// Commit is the resolved commit on the rebase branch
+	Commit string
+	// Overwrite replaces an already existing fixed carry
+	Overwrite bool
+}
+
+func NewRecordCommand(streams options.IOStreams) *cobra.Command {
+	o := &RecordOptions{Common: options.NewCommon(streams), Carries: options.NewCarries(), Commit: "HEAD"}
+
+	cmd := &cobra.Command{
+		Use:          "record <original-sha> --repository=/go/src/k8s.io/kubernetes",
+		Short:        "Records a manually resolved carry as its fixed carry",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(); err != nil {
+				return err
+			}
+			carriesStore, err := o.Carries.NewStore(o.Common.Profile)
+			if err != nil {
+				return err
+			}
+			recordAction := carry.NewRecordFix(carry.RecordFixOptions{
+				RepositoryDir:  o.Common.RepositoryDir,
+				Profile:        o.Common.Profile,
+				Store:          carriesStore,
+				Version:        o.Carries.Version,
+				Original:       args[0],
+				Commit:         o.Commit,
+				ExplicitCommit: c.Flags().Changed("commit"),
+				Overwrite:      o.Overwrite,
+			}, o.Out)
+			return recordAction.Run()
+		},
+	}
+	o.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *RecordOptions) AddFlags(flags *pflag.FlagSet) {
+	o.Common.AddRepositoryFlags(flags)
+	o.Carries.AddFlags(flags)
+	flags.StringVar(&o.Commit, "commit", o.Commit, "Resolved commit on the rebase branch")
+	flags.BoolVar(&o.Overwrite, "overwrite", o.Overwrite, "Replace an already existing fixed carry")
+}
+
+func (o *RecordOptions) Complete() error {
+	if err := o.Common.CompleteRepositoryDir(); err != nil {
+		return err
+	}
+	return o.Common.CompleteProfile()
+}
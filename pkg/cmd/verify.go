@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/openshift/rebase/pkg/carry"
+	"github.com/openshift/rebase/pkg/options"
+)
+
+type VerifyOptions struct {
+	options.Common
+	options.Carries
+
+	// To is the upstream revision the carries are checked against
+	To string
+	// Prune removes orphaned carries
+	Prune bool
+}
+
+func NewVerifyCommand(streams options.IOStreams) *cobra.Command {
+	o := &VerifyOptions{Common: options.NewCommon(streams), Carries: options.NewCarries()}
+
+	cmd := &cobra.Command{
+		Use:          "verify --repository=/go/src/k8s.io/kubernetes --from=v1.26.0",
+		Short:        "Checks fixed and additional carries are valid patches, still used and applying onto the target",
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Common.Complete(); err != nil {
+				return err
+			}
+			carriesStore, err := o.Carries.NewStore(o.Common.Profile)
+			if err != nil {
+				return err
+			}
+			verifyAction := carry.NewVerify(carry.VerifyOptions{
+				From:          o.Common.From,
+				To:            o.To,
+				Version:       o.Carries.Version,
+				RepositoryDir: o.Common.RepositoryDir,
+				Profile:       o.Common.Profile,
+				Store:         carriesStore,
+				Fetch:         o.Common.Fetch,
+				KeepDuplicate: carry.DuplicatePreference(o.Common.KeepDuplicate),
+				Prune:         o.Prune,
+			}, o.Out)
+			return verifyAction.Run()
+		},
+	}
+	o.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *VerifyOptions) AddFlags(flags *pflag.FlagSet) {
+	o.Common.AddFlags(flags)
+	o.Carries.AddFlags(flags)
+	flags.StringVar(&o.To, "to", o.To, "Upstream revision the carries are checked against, defaults to the upstream branch of the profile")
+	flags.BoolVar(&o.Prune, "prune", o.Prune, "Remove orphaned fixed carries, which are not carries since --from anymore")
+}
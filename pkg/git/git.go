@@ -1,15 +1,24 @@
 package git
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"k8s.io/klog/v2"
 
 	gitv5 "github.com/go-git/go-git/v5"
+	gitv5config "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	gitv5object "github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/openshift/rebase/pkg/profile"
 )
 
 // Git provides an interface for interacting with a git repository.
@@ -22,37 +31,116 @@ type Git interface {
 	Apply(patch string) error
 	// Apply a patch with 3-way merge
 	Apply3Way(patch string) error
+	// AddWorktree creates a detached worktree at path, returning a repository operating on it
+	AddWorktree(path, commitish string) (Git, error)
+	// BranchExists returns information whether a local branch exists
+	BranchExists(name string) (bool, error)
+	// CheckApply checks whether a patch applies, with 3-way merge, without applying it
+	CheckApply(patch string) error
 	// Checkout the specified remote
 	Checkout(remote string) error
 	// CreateBranch creates a named branch based on remote
 	CreateBranch(name, remote string) error
 	// CherryPick invokes the cherry-pick command
 	CherryPick(sha string) error
+	// Conflicts returns the list of files which failed to merge during
+	// the current cherry-pick or apply
+	Conflicts() ([]Conflict, error)
 	// RetryCherryPick invokes the cherry-pick command with recursive strategy and theirs option
 	RetryCherryPick(sha string) error
 	// Commit returns commit for a given has
 	Commit(hash plumbing.Hash) (*gitv5object.Commit, error)
-	// LogFromTag returns a list of carry commits from provided tag
-	LogFromTag(tag string) ([]*gitv5object.Commit, error)
+	// ContinueApply continues the current apply command, after conflicts were resolved
+	ContinueApply() error
+	// ContinueCherryPick continues the current cherry-pick command, after conflicts were resolved
+	ContinueCherryPick() error
+	// CommitAs creates a commit with the changes of commit, and the author and message
+	// of original, without updating any branch. Returns the sha of the created commit.
+	CommitAs(commit, original string) (string, error)
+	// CurrentBranch returns the name of the checked out branch, or the sha of HEAD when detached
+	CurrentBranch() (string, error)
+	// Describe returns the closest tag reachable from revision
+	Describe(revision string) (string, error)
+	// FormatPatch returns the commit formatted as a patch, suitable for git am
+	FormatPatch(commit string) (string, error)
+	// GitDir returns the path to the repository's .git directory
+	GitDir() (string, error)
+	// InProgress returns information whether a cherry-pick or an apply is in progress
+	InProgress() (cherryPick bool, apply bool, err error)
+	// IsAncestor returns information whether commit is an ancestor of revision
+	IsAncestor(commit, revision string) (bool, error)
+	// LastMerge returns the sha of the topologically latest merge commit reachable
+	// from revision, but not from exclude, whose message contains grep, or empty
+	// string when there's none
+	LastMerge(revision, exclude, grep string) (string, error)
+	// FirstParents returns shas of the first-parent chain of revision, stopping
+	// at commits reachable from exclude, oldest first
+	FirstParents(revision, exclude string) ([]string, error)
+	// MergedCommits returns shas of non-merge commits brought by the second parent
+	// of a merge, which are not reachable from exclude, in topological order, oldest first
+	MergedCommits(merge, exclude string) ([]string, error)
 	// Merge remote branch
 	Merge(remote string) error
+	// Commits returns shas of non-merge commits in the revision range, the oldest first
+	Commits(revisionRange string) ([]string, error)
+	// ChangedFiles returns paths of files changed by the commit
+	ChangedFiles(commit string) ([]string, error)
+	// MergeSubjects returns subjects of merge commits reachable from revision,
+	// and matching grep pattern, indexed by their sha
+	MergeSubjects(revision, grep string) (map[string]string, error)
+	// PatchIDs returns stable patch ids of non-merge commits in revisions, indexed by their sha
+	PatchIDs(revisions ...string) (map[string]string, error)
+	// CommitPatchIDs returns stable patch ids of the commits, without walking
+	// their history, indexed by their sha, commits without changes are missing
+	CommitPatchIDs(shas ...string) (map[string]string, error)
+	// RemoveWorktree removes the worktree at path, along with any changes in it
+	RemoveWorktree(path string) error
+	// ResolveRevision returns the sha of the commit any revision points to, e.g. an
+	// annotated or lightweight tag, branch, sha or HEAD~1, an unknown revision
+	// results in an error listing tags and branches with close names
+	ResolveRevision(revision string) (string, error)
 	// Status prints current status of repository
 	Status() error
 }
 
+// Conflict describes a file which failed to merge.
+type Conflict struct {
+	// Path is the file path relative to the repository root
+	Path string `json:"path"`
+	// Hunks is the number of conflicting hunks in the file, zero when the
+	// conflict is not about the content, eg. the file was removed
+	Hunks int `json:"hunks"`
+}
+
+func (c Conflict) String() string {
+	switch c.Hunks {
+	case 0:
+		return c.Path
+	case 1:
+		return fmt.Sprintf("%s (1 hunk)", c.Path)
+	}
+	return fmt.Sprintf("%s (%d hunks)", c.Path, c.Hunks)
+}
+
 // OpenGit opens path as a git repository, ensuring that remotes contain
-// both upstream kubernetes and openshift remotes properly configured.
-func OpenGit(path string) (Git, error) {
+// both upstream and downstream remotes from the profile properly configured.
+// When setupRemotes is set, missing remotes are created and both remotes
+// are fetched.
+func OpenGit(path string, profile *profile.Profile, setupRemotes bool) (Git, error) {
 	klog.V(2).Infof("Using %s as git repository", path)
-	repository, err := gitv5.PlainOpen(path)
+	gitRepo, err := open(path)
 	if err != nil {
 		return nil, err
 	}
-	gitRepo := &git{repository: repository, path: path}
-	klog.V(2).Infof("Checking if openshift and upstream remotes are configured..")
-	if err := gitRepo.checkRemotes(); err != nil {
+	klog.V(2).Infof("Checking if %s and %s remotes are configured..", profile.Downstream.Remote, profile.Upstream.Remote)
+	if err := gitRepo.checkRemotes(profile, setupRemotes); err != nil {
 		return nil, err
 	}
+	if setupRemotes {
+		if err := gitRepo.fetchRemotes(profile); err != nil {
+			return nil, err
+		}
+	}
 	return gitRepo, nil
 }
 
@@ -61,24 +149,58 @@ type git struct {
 	repository *gitv5.Repository
 }
 
-// checkRemotes ensures both openshift and upstream remotes are properly configured
-func (git *git) checkRemotes() error {
-	for _, remote := range []struct {
-		name string
-		path string
-	}{
+// open opens path as a git repository, path can point to a worktree
+func open(path string) (*git, error) {
+	repository, err := gitv5.PlainOpenWithOptions(path, &gitv5.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		return nil, err
+	}
+	return &git{repository: repository, path: path}, nil
+}
+
+// remote describes a git remote required for the rebase
+type remote struct {
+	name string
+	// path is the repository location, used to match both SSH and HTTPS URLs
+	path string
+	// url is used when creating the remote
+	url string
+	// refs lists the refs which should be fetched from the remote
+	refs []string
+}
+
+// remotes lists the remotes required for the rebase
+func remotes(profile *profile.Profile) []remote {
+	return []remote{
 		{
-			name: "openshift",
-			path: "github.com:openshift/kubernetes.git",
+			name: profile.Downstream.Remote,
+			path: profile.Downstream.Path(),
+			url:  profile.Downstream.URL(),
+			refs: []string{profile.Downstream.Branch},
 		},
 		{
-			name: "upstream",
-			path: "github.com:kubernetes/kubernetes.git",
+			name: profile.Upstream.Remote,
+			path: profile.Upstream.Path(),
+			url:  profile.Upstream.URL(),
+			refs: []string{profile.Upstream.Branch, "--tags"},
 		},
-	} {
+	}
+}
+
+// checkRemotes ensures both downstream and upstream remotes are properly configured,
+// optionally creating the missing ones
+func (git *git) checkRemotes(profile *profile.Profile, createMissing bool) error {
+	for _, remote := range remotes(profile) {
 		gitRemote, err := git.repository.Remote(remote.name)
+		if errors.Is(err, gitv5.ErrRemoteNotFound) && createMissing {
+			klog.Infof("Creating remote %s -> %s", remote.name, remote.url)
+			gitRemote, err = git.repository.CreateRemote(&gitv5config.RemoteConfig{
+				Name: remote.name,
+				URLs: []string{remote.url},
+			})
+		}
 		if err != nil {
-			return err
+			return fmt.Errorf("Error reading remote %s: %w", remote.name, err)
 		}
 		config := gitRemote.Config()
 		// URLs the URLs of a remote repository. It must be non-empty. Fetch will
@@ -87,8 +209,7 @@ func (git *git) checkRemotes() error {
 			return fmt.Errorf("no fetch URLs, remote=%s", remote.name)
 		}
 		fetchURL := config.URLs[0]
-		// TODO: add auto-updating remotes if the above are missing, there's CreateRemote function
-		if !strings.Contains(fetchURL, remote.path) {
+		if normalizeURL(fetchURL) != strings.ToLower(remote.path) {
 			return fmt.Errorf("fetch URL does not match, remote=%s path=%s", remote.name, remote.path)
 		}
 		klog.V(2).Infof("%s -> %s - OK", remote.name, fetchURL)
@@ -96,32 +217,103 @@ func (git *git) checkRemotes() error {
 	return nil
 }
 
-// LogFromTag returns a list of carry commits from provided tag
-func (git *git) LogFromTag(tag string) ([]*gitv5object.Commit, error) {
-	tagHash, err := git.repository.Tag(tag)
-	if err != nil {
-		return nil, err
+// fetchRemotes fetches the required refs from downstream and upstream remotes
+func (git *git) fetchRemotes(profile *profile.Profile) error {
+	for _, remote := range remotes(profile) {
+		klog.Infof("Fetching %s...", remote.name)
+		if err := git.runGit(append([]string{"fetch", remote.name}, remote.refs...)...); err != nil {
+			return fmt.Errorf("Error fetching %s: %w", remote.name, err)
+		}
+	}
+	return nil
+}
+
+// normalizeURL turns SSH (git@github.com:org/repo.git, ssh://git@github.com:22/org/repo)
+// and HTTPS (https://github.com/org/repo.git) URLs into lower case host/org/repo form,
+// without the user and port
+func normalizeURL(rawURL string) string {
+	var host, path string
+	if strings.Contains(rawURL, "://") {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			return rawURL
+		}
+		host, path = parsed.Hostname(), parsed.Path
+	} else {
+		// scp-like syntax uses colon to separate host from path
+		var ok bool
+		if host, path, ok = strings.Cut(rawURL, ":"); !ok {
+			return rawURL
+		}
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
 	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return strings.ToLower(host + "/" + path)
+}
+
+// LastMerge returns the sha of the topologically latest merge commit reachable
+// from revision, but not from exclude, whose message contains grep, or empty
+// string when there's none
+func (git *git) LastMerge(revision, exclude, grep string) (string, error) {
+	return git.outputGit("rev-list", "-1", "--merges", "--topo-order", "--fixed-strings", "--grep="+grep, revision, "^"+exclude)
+}
+
+// FirstParents returns shas of the first-parent chain of revision, stopping
+// at commits reachable from exclude, oldest first
+func (git *git) FirstParents(revision, exclude string) ([]string, error) {
+	return git.revList("--first-parent", "--reverse", revision, "^"+exclude)
+}
+
+// MergedCommits returns shas of non-merge commits brought by the second parent
+// of a merge, which are not reachable from exclude, in topological order, oldest first
+func (git *git) MergedCommits(merge, exclude string) ([]string, error) {
+	return git.revList("--reverse", "--topo-order", "--no-merges", merge+"^2", "^"+merge+"^1", "^"+exclude)
+}
+
+// Commits returns shas of non-merge commits in the revision range, the oldest first
+func (git *git) Commits(revisionRange string) ([]string, error) {
+	return git.revList("--reverse", "--topo-order", "--no-merges", revisionRange)
+}
 
-	commit, err := git.repository.TagObject(tagHash.Hash())
+// ChangedFiles returns paths of files changed by the commit
+func (git *git) ChangedFiles(commit string) ([]string, error) {
+	output, err := git.rawOutputGit("diff-tree", "--no-commit-id", "--name-only", "-r", "--root", "-z", commit)
 	if err != nil {
 		return nil, err
 	}
+	var files []string
+	for _, path := range strings.Split(output, "\x00") {
+		if len(path) > 0 {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
 
-	o := &gitv5.LogOptions{Since: &commit.Tagger.When, Order: gitv5.LogOrderCommitterTime}
-	iter, err := git.repository.Log(o)
+// revList returns shas listed by rev-list with the given arguments
+func (git *git) revList(args ...string) ([]string, error) {
+	output, err := git.outputGit(append([]string{"rev-list"}, args...)...)
 	if err != nil {
 		return nil, err
 	}
-	defer iter.Close()
-
-	commits := make([]*gitv5object.Commit, 0)
-	iter.ForEach(func(c *gitv5object.Commit) error {
-		commits = append(commits, c)
-		return nil
-	})
+	if len(output) == 0 {
+		return nil, nil
+	}
+	return strings.Split(output, "\n"), nil
+}
 
-	return commits, nil
+// BranchExists returns information whether a local branch exists
+func (git *git) BranchExists(name string) (bool, error) {
+	_, err := git.repository.Reference(plumbing.NewBranchReferenceName(name), false)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // Checkout the specified remote
@@ -145,6 +337,63 @@ func (git *git) Merge(remote string) error {
 	return git.runGit("merge", "--strategy", "ours", remote, "--no-edit")
 }
 
+// MergeSubjects returns subjects of merge commits reachable from revision,
+// and matching grep pattern, indexed by their sha
+func (git *git) MergeSubjects(revision, grep string) (map[string]string, error) {
+	output, err := git.outputGit("log", "--merges", "--format=%H %s", "--grep", grep, revision)
+	if err != nil {
+		return nil, err
+	}
+	subjects := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		sha, subject, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		subjects[sha] = subject
+	}
+	return subjects, nil
+}
+
+// PatchIDs returns stable patch ids of non-merge commits in revisions, indexed by their sha
+func (git *git) PatchIDs(revisions ...string) (map[string]string, error) {
+	return git.patchIDs(append([]string{"log", "--no-merges", "--no-color", "-p", "--format=%H"}, revisions...)...)
+}
+
+// CommitPatchIDs returns stable patch ids of the commits, without walking
+// their history, indexed by their sha, commits without changes are missing
+func (git *git) CommitPatchIDs(shas ...string) (map[string]string, error) {
+	if len(shas) == 0 {
+		return map[string]string{}, nil
+	}
+	return git.patchIDs(append([]string{"log", "--no-walk=unsorted", "--no-merges", "--no-color", "-p", "--format=%H"}, shas...)...)
+}
+
+// patchIDs returns stable patch ids of commits printed by the log command
+func (git *git) patchIDs(logArgs ...string) (map[string]string, error) {
+	log, err := git.outputGit(logArgs...)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "patch-id", "--stable")
+	klog.V(2).Infof("Invoking %s...", cmd)
+	cmd.Dir = git.path
+	cmd.Stdin = strings.NewReader(log + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", cmd, err)
+	}
+	patchIDs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		patchID, sha, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		patchIDs[sha] = patchID
+	}
+	return patchIDs, nil
+}
+
 // CherryPick invokes the cherry-pick command
 func (git *git) CherryPick(sha string) error {
 	return git.runGit("cherry-pick", sha)
@@ -170,14 +419,320 @@ func (git *git) Apply3Way(patch string) error {
 	return git.runGit("am", "--3way", patch)
 }
 
+// CheckApply checks whether a patch applies, with 3-way merge, without applying it
+func (git *git) CheckApply(patch string) error {
+	_, err := git.outputGit("apply", "--check", "--3way", patch)
+	return err
+}
+
+// AddWorktree creates a detached worktree at path, returning a repository operating on it
+func (git *git) AddWorktree(path, commitish string) (Git, error) {
+	if err := git.runGit("worktree", "add", "--detach", path, commitish); err != nil {
+		return nil, err
+	}
+	return open(path)
+}
+
+// RemoveWorktree removes the worktree at path, along with any changes in it
+func (git *git) RemoveWorktree(path string) error {
+	return git.runGit("worktree", "remove", "--force", path)
+}
+
+// ResolveRevision returns the sha of the commit any revision points to
+func (git *git) ResolveRevision(revision string) (string, error) {
+	sha, err := git.outputGit("rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err == nil {
+		return sha, nil
+	}
+	klog.V(2).Infof("Resolving %s failed: %v", revision, err)
+	matches, err := git.closeRefs(revision)
+	if err != nil {
+		klog.V(2).Infof("Looking for refs similar to %s failed: %v", revision, err)
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("Unknown revision %q", revision)
+	}
+	return "", fmt.Errorf("Unknown revision %q, close matches: %s", revision, strings.Join(matches, ", "))
+}
+
+// maxCloseRefs is the maximum number of close matches suggested for an unknown revision
+const maxCloseRefs = 5
+
+// closeRefs returns tags and branches whose names are close to the ref the
+// revision is based on, the closest first.
+func (git *git) closeRefs(revision string) ([]string, error) {
+	output, err := git.outputGit("for-each-ref", "--format=%(refname:short)", "refs/tags", "refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	return closeMatches(revision, strings.Fields(output)), nil
+}
+
+// closeMatches returns refs whose names are close to the ref the revision is
+// based on, the closest first.
+func closeMatches(revision string, refs []string) []string {
+	name := strings.ToLower(revision)
+	if i := strings.IndexAny(name, "~^@:"); i >= 0 {
+		name = name[:i]
+	}
+	if len(name) == 0 {
+		return nil
+	}
+	maxDistance := len(name) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	distances := make(map[string]int)
+	var matches []string
+	for _, ref := range refs {
+		candidate := strings.ToLower(ref)
+		distance := editDistance(name, candidate)
+		// remote branches are matched by their name without the remote too
+		if _, branch, ok := strings.Cut(candidate, "/"); ok {
+			if d := editDistance(name, branch); d < distance {
+				distance = d
+			}
+		}
+		if distance > maxDistance && !strings.HasSuffix(candidate, name) && !strings.HasPrefix(candidate, name) {
+			continue
+		}
+		distances[ref] = distance
+		matches = append(matches, ref)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if distances[matches[i]] != distances[matches[j]] {
+			return distances[matches[i]] < distances[matches[j]]
+		}
+		return matches[i] < matches[j]
+	})
+	if len(matches) > maxCloseRefs {
+		matches = matches[:maxCloseRefs]
+	}
+	return matches
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
 // AbortApply a patch
 func (git *git) AbortApply() error {
 	return git.runGit("am", "--abort")
 }
 
+// Conflicts returns the list of files which failed to merge during
+// the current cherry-pick or apply
+func (git *git) Conflicts() ([]Conflict, error) {
+	unmerged, err := git.outputGit("diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []Conflict
+	for _, path := range strings.Split(unmerged, "\n") {
+		if len(path) == 0 {
+			continue
+		}
+		hunks, err := countConflictMarkers(filepath.Join(git.path, path))
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, Conflict{Path: path, Hunks: hunks})
+	}
+	if len(conflicts) > 0 {
+		return conflicts, nil
+	}
+	// apply without 3-way merge does not leave unmerged files, check which
+	// hunks of the current patch fail to apply instead
+	_, apply, err := git.InProgress()
+	if err != nil || !apply {
+		return nil, err
+	}
+	gitDir, err := git.GitDir()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "apply", "--check", filepath.Join(gitDir, "rebase-apply", "patch"))
+	cmd.Dir = git.path
+	// failure is expected here, the errors are parsed from the output
+	output, _ := cmd.CombinedOutput()
+	index := make(map[string]int)
+	for _, line := range strings.Split(string(output), "\n") {
+		matches := applyErrorRE.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		path := matches[1] + matches[2]
+		i, exists := index[path]
+		if !exists {
+			i = len(conflicts)
+			index[path] = i
+			conflicts = append(conflicts, Conflict{Path: path})
+		}
+		// only failed hunks are counted, missing files are not content conflicts
+		if len(matches[1]) > 0 {
+			conflicts[i].Hunks++
+		}
+	}
+	return conflicts, nil
+}
+
+// applyErrorRE matches errors printed by apply for every hunk which fails
+// to apply, and for every file which is missing or already exists
+var applyErrorRE = regexp.MustCompile(`^error: (?:patch failed: (.+):\d+|(.+): (?:does not exist in index|No such file or directory|already exists in (?:index|working directory)))$`)
+
+// countConflictMarkers returns the number of conflict hunks in a file, files
+// which do not exist have none
+func countConflictMarkers(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "<<<<<<< ") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ContinueApply continues the current apply command, after conflicts were resolved
+func (git *git) ContinueApply() error {
+	return git.runGit("am", "--continue")
+}
+
+// ContinueCherryPick continues the current cherry-pick command, after conflicts were resolved
+func (git *git) ContinueCherryPick() error {
+	// core.editor prevents git from opening an editor for the commit message
+	return git.runGit("-c", "core.editor=true", "cherry-pick", "--continue")
+}
+
+// CommitAs creates a commit with the changes of commit, and the author and message
+// of original, without updating any branch. Returns the sha of the created commit.
+func (git *git) CommitAs(commit, original string) (string, error) {
+	resolved, err := git.commitObject(commit)
+	if err != nil {
+		return "", err
+	}
+	originalCommit, err := git.commitObject(original)
+	if err != nil {
+		return "", err
+	}
+	created := &gitv5object.Commit{
+		Author:       originalCommit.Author,
+		Committer:    resolved.Committer,
+		Message:      originalCommit.Message,
+		TreeHash:     resolved.TreeHash,
+		ParentHashes: resolved.ParentHashes,
+	}
+	object := git.repository.Storer.NewEncodedObject()
+	if err := created.Encode(object); err != nil {
+		return "", err
+	}
+	hash, err := git.repository.Storer.SetEncodedObject(object)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// commitObject returns the commit a revision points to
+func (git *git) commitObject(revision string) (*gitv5object.Commit, error) {
+	hash, err := git.repository.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving %s: %w", revision, err)
+	}
+	return git.repository.CommitObject(*hash)
+}
+
+// Describe returns the closest tag reachable from revision
+func (git *git) Describe(revision string) (string, error) {
+	return git.outputGit("describe", "--tags", "--abbrev=0", revision)
+}
+
+// FormatPatch returns the commit formatted as a patch, suitable for git am
+func (git *git) FormatPatch(commit string) (string, error) {
+	return git.rawOutputGit("format-patch", "-1", "--stdout", commit)
+}
+
+// CurrentBranch returns the name of the checked out branch, or the sha of HEAD when detached
+func (git *git) CurrentBranch() (string, error) {
+	if branch, err := git.outputGit("symbolic-ref", "--short", "-q", "HEAD"); err == nil && len(branch) > 0 {
+		return branch, nil
+	}
+	return git.outputGit("rev-parse", "HEAD")
+}
+
+// GitDir returns the path to the repository's .git directory
+func (git *git) GitDir() (string, error) {
+	gitDir, err := git.outputGit("rev-parse", "--git-dir")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(git.path, gitDir)
+	}
+	return gitDir, nil
+}
+
+// InProgress returns information whether a cherry-pick or an apply is in progress
+func (git *git) InProgress() (bool, bool, error) {
+	gitDir, err := git.GitDir()
+	if err != nil {
+		return false, false, err
+	}
+	cherryPick, err := exists(filepath.Join(gitDir, "CHERRY_PICK_HEAD"))
+	if err != nil {
+		return false, false, err
+	}
+	apply, err := exists(filepath.Join(gitDir, "rebase-apply", "applying"))
+	if err != nil {
+		return false, false, err
+	}
+	return cherryPick, apply, nil
+}
+
+// IsAncestor returns information whether commit is an ancestor of revision
+func (git *git) IsAncestor(commit, revision string) (bool, error) {
+	_, err := git.outputGit("merge-base", "--is-ancestor", commit, revision)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // Status prints current status of repository
 func (git *git) Status() error {
-	// TODO runGit should return error and outputs separately
 	return git.runGit("status")
 }
 
@@ -194,25 +749,36 @@ func (git *git) runGit(args ...string) error {
 	return err
 }
 
-// CommitsByDate sorts a list of commits by commit date
-type CommitsByDate []*gitv5object.Commit
+// outputGit invokes git returning its standard output, trimmed of surrounding
+// whitespaces, separately from the error
+func (git *git) outputGit(args ...string) (string, error) {
+	output, err := git.rawOutputGit(args...)
+	return strings.TrimSpace(output), err
+}
 
-func (s CommitsByDate) Len() int      { return len(s) }
-func (s CommitsByDate) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+// rawOutputGit invokes git returning its standard output, separately from the error
+func (git *git) rawOutputGit(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	klog.V(2).Infof("Invoking %s...", cmd)
+	cmd.Dir = git.path
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	klog.V(3).Infof(stderr.String())
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w: %s", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
 
-func (s CommitsByDate) Less(i, j int) bool {
-	iDate := s[i].Committer.When
-	jDate := s[j].Committer.When
-	comparison := iDate.Compare(jDate)
-	if comparison < 0 {
-		return true
-	} else if comparison == 0 {
-		// during rebase we frequently rebase the PR several times, this will cause
-		// a group of several commits to have identical commit date, to ensure proper
-		// ordering in those cases we will fallback to original author date
-		iAuthorDate := s[i].Author.When
-		jAuthorDate := s[j].Author.When
-		return iAuthorDate.Before(jAuthorDate)
+// exists returns information whether a file exists
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
 	}
-	return false
+	return false, err
 }
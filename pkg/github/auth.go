@@ -0,0 +1,228 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v56/github"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// App describes a GitHub App installation the client authenticates as.
+type App struct {
+	// ID is the GitHub App id
+	ID int64
+	// InstallationID is the id of the App installation in the organization
+	InstallationID int64
+	// PrivateKeyFile is the path to the PEM encoded App private key
+	PrivateKeyFile string
+}
+
+// tokenRefreshMargin is how long before expiry the installation token is refreshed
+const tokenRefreshMargin = time.Minute
+
+// ReadTokenFile returns the token stored in a file, without surrounding whitespaces.
+func ReadTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Error reading token file %s: %w", path, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if len(token) == 0 {
+		return "", fmt.Errorf("Token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// GHToken returns the token the gh CLI uses for a host, or empty string
+// when there's none. The token is read with gh auth token, which supports
+// tokens kept in the system keyring, falling back to hosts.yml when gh
+// is not installed, or fails.
+func GHToken(host string) (string, error) {
+	token, err := ghAuthToken(host)
+	if err == nil && len(token) > 0 {
+		return token, nil
+	}
+	if err != nil {
+		klog.V(2).Infof("Reading token with gh auth token failed, falling back to hosts.yml: %v", err)
+	}
+	return ghHostsToken(host)
+}
+
+// ghAuthToken returns the token printed by gh auth token for a host.
+func ghAuthToken(host string) (string, error) {
+	path, err := exec.LookPath("gh")
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(path, "auth", "token", "--hostname", host)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w: %s", cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ghHostsToken returns the token the gh CLI stores for a host in its hosts.yml,
+// which is written by gh versions not using the system keyring.
+func ghHostsToken(host string) (string, error) {
+	path := ghHostsPath()
+	if len(path) == 0 {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error reading %s: %w", path, err)
+	}
+	hosts := make(map[string]struct {
+		OAuthToken string `yaml:"oauth_token"`
+	})
+	if err := yaml.Unmarshal(data, &hosts); err != nil {
+		return "", fmt.Errorf("Error parsing %s: %w", path, err)
+	}
+	return hosts[host].OAuthToken, nil
+}
+
+// ghHostsPath returns the location of the gh CLI hosts configuration.
+func ghHostsPath() string {
+	if dir := os.Getenv("GH_CONFIG_DIR"); len(dir) > 0 {
+		return filepath.Join(dir, "hosts.yml")
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); len(dir) > 0 {
+		return filepath.Join(dir, "gh", "hosts.yml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gh", "hosts.yml")
+}
+
+// appTransport authenticates requests with a GitHub App installation token,
+// refreshing it before it expires.
+type appTransport struct {
+	base http.RoundTripper
+	app  App
+	key  *rsa.PrivateKey
+	// newClient returns a client authenticated with the App JWT, used to create installation tokens
+	newClient func(jwt string) *github.Client
+
+	lock      sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// newAppTransport returns a transport authenticating as the App installation.
+func newAppTransport(base http.RoundTripper, app App, newClient func(jwt string) *github.Client) (*appTransport, error) {
+	data, err := os.ReadFile(app.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading GitHub App private key %s: %w", app.PrivateKeyFile, err)
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing GitHub App private key %s: %w", app.PrivateKeyFile, err)
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &appTransport{base: base, app: app, key: key, newClient: newClient}, nil
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.installationToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	// the request must not be modified, see http.RoundTripper
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "token "+token)
+	return t.base.RoundTrip(req)
+}
+
+// installationToken returns a valid installation token, creating a new one when needed.
+func (t *appTransport) installationToken(ctx context.Context) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.token) > 0 && time.Until(t.expiresAt) > tokenRefreshMargin {
+		return t.token, nil
+	}
+	jwt, err := signJWT(t.key, t.app.ID, time.Now())
+	if err != nil {
+		return "", err
+	}
+	klog.V(3).Infof("Creating installation token for GitHub App %d installation %d", t.app.ID, t.app.InstallationID)
+	token, _, err := t.newClient(jwt).Apps.CreateInstallationToken(ctx, t.app.InstallationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("Error creating GitHub App installation token: %w", err)
+	}
+	t.token = token.GetToken()
+	t.expiresAt = token.GetExpiresAt().Time
+	return t.token, nil
+}
+
+// signJWT returns a JWT, signed with RS256, identifying the App.
+func signJWT(key *rsa.PrivateKey, appID int64, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		// backdated to allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		// GitHub accepts at most 10 minutes
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey parses PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,44 @@
+package github
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGHToken(t *testing.T) {
+	configDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(configDir, "hosts.yml"), []byte("github.com:\n  oauth_token: from-hosts\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GH_CONFIG_DIR", configDir)
+
+	binDir := t.TempDir()
+	gh := "#!/bin/sh\n[ \"$4\" = github.com ] && echo from-keyring || exit 1\n"
+	if err := os.WriteFile(filepath.Join(binDir, "gh"), []byte(gh), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		host     string
+		expected string
+	}{
+		{name: "gh auth token", path: binDir, host: "github.com", expected: "from-keyring"},
+		{name: "gh fails", path: binDir, host: "github.example.com", expected: ""},
+		{name: "gh missing", path: t.TempDir(), host: "github.com", expected: "from-hosts"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PATH", test.path)
+			token, err := GHToken(test.host)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token != test.expected {
+				t.Errorf("expected token %q, got %q", test.expected, token)
+			}
+		})
+	}
+}
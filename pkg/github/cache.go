@@ -0,0 +1,81 @@
+package github
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultCacheDir returns the directory holding pull request state, when
+// none is specified.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rebase", "github")
+}
+
+// cache stores pull request state on disk, one file per pull request.
+// Nil cache stores nothing.
+type cache struct {
+	dir string
+}
+
+// path returns the location of the pull request state
+func (c *cache) path(owner, repo string, number int) string {
+	return filepath.Join(c.dir, owner, repo, strconv.Itoa(number)+".json")
+}
+
+// get returns the cached pull request state, or nil when there's none
+func (c *cache) get(owner, repo string, number int) *PullRequest {
+	if c == nil {
+		return nil
+	}
+	path := c.path(owner, repo, number)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			klog.V(3).Infof("Reading cached %s failed: %v", path, err)
+		}
+		return nil
+	}
+	pullRequest := &PullRequest{}
+	if err := json.Unmarshal(data, pullRequest); err != nil {
+		klog.V(3).Infof("Parsing cached %s failed: %v", path, err)
+		return nil
+	}
+	return pullRequest
+}
+
+// put stores the pull request state, replacing the previous one atomically
+func (c *cache) put(pullRequest *PullRequest) error {
+	if c == nil {
+		return nil
+	}
+	path := c.path(pullRequest.Owner, pullRequest.Repo, pullRequest.Number)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(pullRequest)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -2,20 +2,278 @@ package github
 
 import (
 	"context"
-	"os"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/v56/github"
 	"k8s.io/klog/v2"
 )
 
-func IsMerged(number int) (bool, error) {
-	client := github.NewClient(nil)
-	if token := os.Getenv("GITHUB_TOKEN"); len(token) > 0 {
-		client = client.WithAuthToken(token)
-	} else {
+const (
+	// DefaultWorkers is the number of concurrent lookups used when none is specified
+	DefaultWorkers = 8
+	// maxRetries is the number of times a request is retried after hitting a rate limit
+	maxRetries = 3
+	// defaultSecondaryWait is the wait after hitting a secondary rate limit without Retry-After
+	defaultSecondaryWait = time.Minute
+	// DefaultMaxWait is the longest wait for a rate limit reset used when none is specified
+	DefaultMaxWait = 2 * time.Minute
+)
+
+// Options holds the settings of a GitHub client.
+type Options struct {
+	// BaseURL is the GitHub API URL, defaults to https://api.github.com/,
+	// GitHub Enterprise uses https://<host>/api/v3/
+	BaseURL string
+	// UploadURL is the GitHub upload URL, defaults to https://uploads.github.com/,
+	// GitHub Enterprise uses https://<host>/api/uploads/
+	UploadURL string
+	// Token authenticates the requests, anonymous requests are heavily rate limited
+	Token string
+	// App authenticates the requests as a GitHub App installation, takes
+	// precedence over Token
+	App *App
+	// CacheDir is the directory holding pull request state, empty disables the cache
+	CacheDir string
+	// Workers is the number of concurrent lookups, defaults to DefaultWorkers
+	Workers int
+	// MaxWait is the longest wait for a rate limit reset, requests needing
+	// longer waits fail instead, defaults to DefaultMaxWait
+	MaxWait time.Duration
+	// HTTPClient sends the requests, defaults to a client with default transport
+	HTTPClient *http.Client
+}
+
+// Client reads pull requests from GitHub, caching their state on disk.
+// It is safe for concurrent use.
+type Client struct {
+	client  *github.Client
+	cache   *cache
+	workers int
+	maxWait time.Duration
+}
+
+// PullRequest is the merge state of a pull request.
+type PullRequest struct {
+	Owner  string `json:"owner"`
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+	Merged bool   `json:"merged"`
+	// MergeCommitSHA is the commit which merged the pull request, set only when merged
+	MergeCommitSHA string `json:"mergeCommitSHA,omitempty"`
+	// ETag identifies the response the state was read from, used for conditional requests
+	ETag string `json:"etag,omitempty"`
+}
+
+// NewClient returns a client configured with given options.
+func NewClient(o Options) (*Client, error) {
+	httpClient := o.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	baseURL, err := parseURL(o.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing GitHub URL %s: %w", o.BaseURL, err)
+	}
+	uploadURL, err := parseURL(o.UploadURL)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing GitHub upload URL %s: %w", o.UploadURL, err)
+	}
+	newClient := func(httpClient *http.Client) *github.Client {
+		client := github.NewClient(httpClient)
+		if baseURL != nil {
+			client.BaseURL = baseURL
+		}
+		if uploadURL != nil {
+			client.UploadURL = uploadURL
+		}
+		return client
+	}
+
+	var client *github.Client
+	switch {
+	case o.App != nil:
+		transport, err := newAppTransport(httpClient.Transport, *o.App, func(jwt string) *github.Client {
+			return newClient(httpClient).WithAuthToken(jwt)
+		})
+		if err != nil {
+			return nil, err
+		}
+		client = newClient(&http.Client{Transport: transport, Timeout: httpClient.Timeout})
+	case len(o.Token) > 0:
+		client = newClient(httpClient).WithAuthToken(o.Token)
+	default:
 		klog.V(3).Infof("Using the default github token, which might rate limit your requests!")
+		client = newClient(httpClient)
+	}
+	workers := o.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	var c *cache
+	if len(o.CacheDir) > 0 {
+		c = &cache{dir: o.CacheDir}
+	}
+	maxWait := o.MaxWait
+	if maxWait <= 0 {
+		maxWait = DefaultMaxWait
+	}
+	return &Client{client: client, cache: c, workers: workers, maxWait: maxWait}, nil
+}
+
+// parseURL parses the URL ensuring it ends with a slash, empty URL returns nil.
+func parseURL(rawURL string) (*url.URL, error) {
+	if len(rawURL) == 0 {
+		return nil, nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(parsed.Path, "/") {
+		parsed.Path += "/"
+	}
+	return parsed, nil
+}
+
+// PullRequest returns the merge state of a pull request in owner/repo. Merged
+// pull requests are served from the cache, others are revalidated with
+// a conditional request.
+func (c *Client) PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error) {
+	cached := c.cache.get(owner, repo, number)
+	if cached != nil && cached.Merged {
+		// merged pull requests never change
+		klog.V(3).Infof("Using cached state of %s/%s#%d", owner, repo, number)
+		return cached, nil
+	}
+	req, err := c.client.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/pulls/%d", owner, repo, number), nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && len(cached.ETag) > 0 {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	pullRequest := &github.PullRequest{}
+	response, err := c.do(ctx, req, pullRequest)
+	if cached != nil && response != nil && response.Response != nil && response.StatusCode == http.StatusNotModified {
+		klog.V(3).Infof("State of %s/%s#%d was not modified", owner, repo, number)
+		return cached, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &PullRequest{
+		Owner:  owner,
+		Repo:   repo,
+		Number: number,
+		Merged: pullRequest.GetMerged(),
+		ETag:   response.Header.Get("ETag"),
+	}
+	if result.Merged {
+		result.MergeCommitSHA = pullRequest.GetMergeCommitSHA()
+	}
+	if err := c.cache.put(result); err != nil {
+		klog.Warningf("Caching state of %s/%s#%d failed: %v", owner, repo, number, err)
+	}
+	return result, nil
+}
+
+// PullRequests returns the merge state of pull requests in owner/repo, indexed
+// by their number, reading them concurrently. Pull requests which could not
+// be read are missing from the result, and reported in the error.
+func (c *Client) PullRequests(ctx context.Context, owner, repo string, numbers []int) (map[int]*PullRequest, error) {
+	type result struct {
+		number      int
+		pullRequest *PullRequest
+		err         error
+	}
+	jobs := make(chan int)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range jobs {
+				pullRequest, err := c.PullRequest(ctx, owner, repo, number)
+				results <- result{number: number, pullRequest: pullRequest, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, number := range numbers {
+			jobs <- number
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	pullRequests := make(map[int]*PullRequest, len(numbers))
+	var errs []error
+	for r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("Error reading pull request #%d: %w", r.number, r.err))
+			continue
+		}
+		pullRequests[r.number] = r.pullRequest
+	}
+	return pullRequests, errors.Join(errs...)
+}
+
+// do sends the request, waiting and retrying when a rate limit was hit, unless
+// the wait is longer than the maximum one.
+func (c *Client) do(ctx context.Context, req *http.Request, v interface{}) (*github.Response, error) {
+	for attempt := 0; ; attempt++ {
+		response, err := c.client.Do(ctx, req, v)
+		if response != nil {
+			klog.V(3).Infof("Remaining rate with current token is %s", response.Rate.String())
+		}
+		wait, limited := rateLimitWait(err)
+		if !limited || attempt >= maxRetries {
+			return response, err
+		}
+		if wait > c.maxWait {
+			return response, fmt.Errorf("GitHub rate limit requires waiting %s, longer than %s: %w", wait.Round(time.Second), c.maxWait, err)
+		}
+		klog.Warningf("Hit GitHub rate limit, waiting %s before retrying...", wait.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return response, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// IsNotFound returns information whether the error informs the requested
+// resource, e.g. a pull request, does not exist.
+func IsNotFound(err error) bool {
+	var responseErr *github.ErrorResponse
+	return errors.As(err, &responseErr) && responseErr.Response != nil && responseErr.Response.StatusCode == http.StatusNotFound
+}
+
+// rateLimitWait returns how long to wait before retrying, if the error
+// informs a primary or secondary rate limit was hit.
+func rateLimitWait(err error) (time.Duration, bool) {
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		wait := time.Until(rateLimitErr.Rate.Reset.Time) + time.Second
+		if wait < time.Second {
+			wait = time.Second
+		}
+		return wait, true
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+		return defaultSecondaryWait, true
 	}
-	isMerged, response, err := client.PullRequests.IsMerged(context.Background(), "kubernetes", "kubernetes", number)
-	klog.V(3).Infof("Remaining rate with current token is %s", response.Rate.String())
-	return isMerged, err
+	return 0, false
 }
@@ -0,0 +1,192 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client talking to a test server serving handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc, o Options) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	o.BaseURL = server.URL + "/"
+	client, err := NewClient(o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return client
+}
+
+// pullNumber returns the number of the pull request requested, or 0.
+func pullNumber(r *http.Request) int {
+	number, _ := strconv.Atoi(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	return number
+}
+
+func TestPullRequestCache(t *testing.T) {
+	var requests, notModified atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		number := pullNumber(r)
+		etag := fmt.Sprintf(`"etag-%d"`, number)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if number == 1 {
+			fmt.Fprint(w, `{"number": 1, "merged": true, "merge_commit_sha": "abc"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"number": %d, "merged": false}`, number)
+	}
+	client := newTestClient(t, handler, Options{CacheDir: t.TempDir()})
+
+	for i := 0; i < 2; i++ {
+		merged, err := client.PullRequest(context.Background(), "o", "r", 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !merged.Merged || merged.MergeCommitSHA != "abc" {
+			t.Errorf("unexpected merged pull request %#v", merged)
+		}
+		open, err := client.PullRequest(context.Background(), "o", "r", 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if open.Merged || open.ETag != `"etag-2"` {
+			t.Errorf("unexpected open pull request %#v", open)
+		}
+	}
+	// merged pull request is served from the cache, open one is revalidated
+	if requests.Load() != 3 {
+		t.Errorf("expected 3 requests, got %d", requests.Load())
+	}
+	if notModified.Load() != 1 {
+		t.Errorf("expected 1 conditional request, got %d", notModified.Load())
+	}
+}
+
+func TestPullRequestsWorkers(t *testing.T) {
+	var mu sync.Mutex
+	current, peak := 0, 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current++
+		if current > peak {
+			peak = current
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		current--
+		mu.Unlock()
+		number := pullNumber(r)
+		if number == 13 {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "Not Found"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"number": %d, "merged": true, "merge_commit_sha": "sha-%d"}`, number, number)
+	}
+	client := newTestClient(t, handler, Options{Workers: 3})
+
+	var numbers []int
+	for i := 1; i <= 20; i++ {
+		numbers = append(numbers, i)
+	}
+	pullRequests, err := client.PullRequests(context.Background(), "o", "r", numbers)
+	if err == nil || !strings.Contains(err.Error(), "#13") {
+		t.Errorf("expected error reading #13, got %v", err)
+	}
+	if len(pullRequests) != 19 {
+		t.Errorf("expected 19 pull requests, got %d", len(pullRequests))
+	}
+	if pullRequests[7] == nil || pullRequests[7].MergeCommitSHA != "sha-7" {
+		t.Errorf("unexpected pull request #7 %#v", pullRequests[7])
+	}
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent requests, got %d", peak)
+	}
+}
+
+func TestPullRequestNotFound(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "Not Found"}`)
+	}
+	client := newTestClient(t, handler, Options{})
+
+	_, err := client.PullRequest(context.Background(), "o", "r", 1)
+	if !IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if IsNotFound(fmt.Errorf("other error")) {
+		t.Errorf("unexpected not found for other error")
+	}
+}
+
+func TestPullRequestSecondaryRateLimit(t *testing.T) {
+	var requests atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "You have exceeded a secondary rate limit", "documentation_url": "https://docs.github.com/rest/overview/resources-in-the-rest-api#secondary-rate-limits"}`)
+			return
+		}
+		fmt.Fprint(w, `{"number": 1, "merged": true, "merge_commit_sha": "abc"}`)
+	}
+	client := newTestClient(t, handler, Options{})
+
+	pullRequest, err := client.PullRequest(context.Background(), "o", "r", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !pullRequest.Merged {
+		t.Errorf("unexpected pull request %#v", pullRequest)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expected the request to be retried once, got %d requests", requests.Load())
+	}
+}
+
+func TestPullRequestMaxWait(t *testing.T) {
+	var requests atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message": "API rate limit exceeded"}`)
+	}
+	client := newTestClient(t, handler, Options{MaxWait: time.Second})
+
+	done := make(chan error)
+	go func() {
+		_, err := client.PullRequest(context.Background(), "o", "r", 1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "longer than 1s") {
+			t.Errorf("expected rate limit error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("waited for the rate limit reset")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("expected 1 request, got %d", requests.Load())
+	}
+}
@@ -0,0 +1,401 @@
+package lint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	gitv5object "github.com/go-git/go-git/v5/plumbing/object"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/rebase/pkg/carry"
+	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/github"
+	"github.com/openshift/rebase/pkg/profile"
+	"github.com/openshift/rebase/pkg/upstream"
+	"github.com/openshift/rebase/pkg/utils"
+)
+
+// Rule names a convention carry commits are checked against
+type Rule string
+
+const (
+	// RulePrefix checks the subject has a valid UPSTREAM prefix
+	RulePrefix Rule = "prefix"
+	// RuleUpstreamPR checks picked pull requests exist upstream
+	RuleUpstreamPR Rule = "upstream-pr"
+	// RuleLabels checks vendored and staging changes are labeled with the matching action
+	RuleLabels Rule = "labels"
+	// RuleDrop checks <drop> commits change generated files only
+	RuleDrop Rule = "drop"
+	// RuleMixed checks commits do not mix generated and hand-written changes
+	RuleMixed Rule = "mixed"
+)
+
+const (
+	vendorDir  = "vendor/"
+	stagingDir = "staging/"
+	// maxListedFiles is the maximum number of files listed in a problem
+	maxListedFiles = 5
+	// headerSize is the size of the file beginning searched for the generated code header
+	headerSize = 4096
+)
+
+// generatedHeaderRE matches the header of generated files, see https://go.dev/s/generatedcode
+var generatedHeaderRE = regexp.MustCompile(`(?m)^(//|#) Code generated .* DO NOT EDIT\.?\s*$`)
+
+// Lint checks commits follow the UPSTREAM commit conventions.
+type Lint struct {
+	revisionRange   string
+	repositoryDir   string
+	profile         *profile.Profile
+	github          *github.Client
+	fetch           bool
+	allowUnverified bool
+	output          string
+	out             io.Writer
+
+	// pullRequests holds merge commits of upstream pull requests, indexed by number
+	pullRequests map[int]string
+}
+
+// Options holds the settings of a lint.
+type Options struct {
+	// RevisionRange is the range of commits to check, a single revision means
+	// the commits since the downstream branch
+	RevisionRange string
+	// RepositoryDir is the kubernetes repository directory
+	RepositoryDir string
+	// Profile describes the downstream and upstream repositories
+	Profile *profile.Profile
+	// GitHub is the client used to check picked pull requests exist, nil
+	// checks the local upstream history only
+	GitHub *github.Client
+	// Fetch sets up and fetches the remotes
+	Fetch bool
+	// AllowUnverified reports picked pull requests which could not be
+	// verified, since GitHub is disabled or unreachable, without failing
+	AllowUnverified bool
+	// Output is the output format, one of carry.OutputFormats or empty for text
+	Output string
+}
+
+// Problem describes a commit breaking a rule.
+type Problem struct {
+	Commit  string `json:"commit" yaml:"commit"`
+	Subject string `json:"subject" yaml:"subject"`
+	Rule    Rule   `json:"rule" yaml:"rule"`
+	Message string `json:"message" yaml:"message"`
+	// Unverified informs the rule could not be checked
+	Unverified bool `json:"unverified,omitempty" yaml:"unverified,omitempty"`
+}
+
+// Result describes the outcome of a lint.
+type Result struct {
+	Range    string    `json:"range" yaml:"range"`
+	Commits  int       `json:"commits" yaml:"commits"`
+	Problems []Problem `json:"problems" yaml:"problems"`
+}
+
+func NewLint(o Options, out io.Writer) *Lint {
+	return &Lint{
+		revisionRange:   o.RevisionRange,
+		repositoryDir:   o.RepositoryDir,
+		profile:         o.Profile,
+		github:          o.GitHub,
+		fetch:           o.Fetch,
+		allowUnverified: o.AllowUnverified,
+		output:          o.Output,
+		out:             out,
+	}
+}
+
+// Run checks every commit in the range, printing the problems found. Returns
+// an error when any problem was found, unverified pull requests count only
+// unless they are allowed.
+func (c *Lint) Run() error {
+	repository, err := git.OpenGit(c.repositoryDir, c.profile, c.fetch)
+	if err != nil {
+		return err
+	}
+	revisionRange := c.revisionRange
+	if len(revisionRange) == 0 {
+		revisionRange = "HEAD"
+	}
+	if !strings.Contains(revisionRange, "..") {
+		revisionRange = c.profile.Downstream.Ref() + ".." + revisionRange
+	}
+	shas, err := repository.Commits(revisionRange)
+	if err != nil {
+		return fmt.Errorf("Error reading commits %s: %w", revisionRange, err)
+	}
+	klog.V(2).Infof("Checking %d commits in %s...", len(shas), revisionRange)
+
+	result := Result{Range: revisionRange, Commits: len(shas), Problems: []Problem{}}
+	for _, sha := range shas {
+		problems, err := c.lintCommit(repository, sha)
+		if err != nil {
+			return err
+		}
+		result.Problems = append(result.Problems, problems...)
+	}
+	if err := c.print(result); err != nil {
+		return err
+	}
+	failed := 0
+	for _, p := range result.Problems {
+		if !p.Unverified || !c.allowUnverified {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Found %d problems in %d commits", failed, len(shas))
+	}
+	return nil
+}
+
+// lintCommit checks a single commit against all the rules.
+func (c *Lint) lintCommit(repository git.Git, sha string) ([]Problem, error) {
+	commit, err := repository.Commit(plumbing.NewHash(sha))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading commit %s: %w", sha, err)
+	}
+	klog.V(5).Infof("Processing %s", commit)
+	subject := utils.FormatMessage(commit.Message)
+	problem := func(rule Rule, format string, args ...interface{}) Problem {
+		return Problem{Commit: sha, Subject: subject, Rule: rule, Message: fmt.Sprintf(format, args...)}
+	}
+
+	parsed, err := carry.Parse(commit.Message)
+	if err != nil {
+		return []Problem{problem(RulePrefix, "%v", err)}, nil
+	}
+	var problems []Problem
+	if parsed.Action == carry.ActionPick {
+		for _, number := range parsed.UpstreamPRs {
+			exists, unverified, err := c.pullRequestExists(repository, number)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case len(unverified) > 0:
+				p := problem(RuleUpstreamPR, "pull request #%d was not found in %s, and could not be verified: %s", number, c.profile.Upstream.Ref(), unverified)
+				p.Unverified = true
+				problems = append(problems, p)
+			case !exists:
+				problems = append(problems, problem(RuleUpstreamPR, "pull request #%d does not exist in %s/%s", number, c.profile.Upstream.Owner, c.profile.Upstream.Name))
+			}
+		}
+	}
+
+	files, err := repository.ChangedFiles(sha)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading files changed by %s: %w", sha, err)
+	}
+	var generated, handWritten, vendored, staging []string
+	for _, file := range files {
+		isGenerated, err := isGenerated(commit, file)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading %s in %s: %w", file, sha, err)
+		}
+		if isGenerated {
+			generated = append(generated, file)
+		} else {
+			handWritten = append(handWritten, file)
+		}
+		switch {
+		case strings.HasPrefix(file, vendorDir):
+			vendored = append(vendored, file)
+		case strings.HasPrefix(file, stagingDir) && !isGenerated:
+			staging = append(staging, file)
+		}
+	}
+
+	// go.sum changes along with go.mod, so they are one change rather than a mix
+	mixed := withoutModuleSums(generated, handWritten)
+	switch parsed.Action {
+	case carry.ActionCarry:
+		if len(vendored) > 0 {
+			problems = append(problems, problem(RuleLabels, "<carry> changes vendored code, which is regenerated during rebase, change staging or pick the upstream pull request instead: %s", listFiles(vendored)))
+		}
+		if len(mixed) > 0 && len(handWritten) > 0 {
+			problems = append(problems, problem(RuleMixed, "mixes generated files (%s) with hand-written ones (%s), regenerate in a separate <drop> commit", listFiles(mixed), listFiles(handWritten)))
+		}
+	case carry.ActionPick:
+		if len(mixed) > 0 && len(handWritten) > 0 {
+			problems = append(problems, problem(RuleMixed, "mixes generated files (%s) with hand-written ones (%s), pick the hand-written changes and regenerate in a separate <drop> commit", listFiles(mixed), listFiles(handWritten)))
+		}
+	case carry.ActionDrop:
+		if len(staging) > 0 {
+			problems = append(problems, problem(RuleLabels, "<drop> changes staging code, which should be an upstream pick or a <carry>: %s", listFiles(staging)))
+		}
+		if other := without(handWritten, staging); len(other) > 0 {
+			problems = append(problems, problem(RuleDrop, "<drop> changes hand-written files, which should be a <carry>: %s", listFiles(other)))
+		}
+	}
+	return problems, nil
+}
+
+// pullRequestExists returns information whether the upstream pull request
+// exists, checking the local upstream history first and GitHub next. Returns
+// the reason why existence could not be verified, when GitHub is disabled or
+// unreachable.
+func (c *Lint) pullRequestExists(repository git.Git, number int) (bool, string, error) {
+	if c.pullRequests == nil {
+		pullRequests, err := upstream.MergedPullRequests(repository, c.profile.Upstream.Ref())
+		if err != nil {
+			return false, "", err
+		}
+		c.pullRequests = pullRequests
+	}
+	if _, ok := c.pullRequests[number]; ok {
+		return true, "", nil
+	}
+	if c.github == nil {
+		return false, "checking GitHub is disabled", nil
+	}
+	_, err := c.github.PullRequest(context.Background(), c.profile.Upstream.Owner, c.profile.Upstream.Name, number)
+	if github.IsNotFound(err) {
+		return false, "", nil
+	}
+	if err != nil {
+		klog.V(2).Infof("Checking pull request #%d on GitHub failed: %v", number, err)
+		return false, fmt.Sprintf("checking GitHub failed: %v", err), nil
+	}
+	return true, "", nil
+}
+
+// withoutModuleSums returns generated files, without go.sum files changed
+// along with the go.mod file of the same module.
+func withoutModuleSums(generated, handWritten []string) []string {
+	modules := make(map[string]bool)
+	for _, file := range handWritten {
+		if path.Base(file) == "go.mod" {
+			modules[path.Dir(file)] = true
+		}
+	}
+	var result []string
+	for _, file := range generated {
+		if path.Base(file) == "go.sum" && modules[path.Dir(file)] {
+			continue
+		}
+		result = append(result, file)
+	}
+	return result
+}
+
+// isGenerated returns information whether the file changed by the commit is
+// generated, based on its path, or on the generated code header. Removed files
+// are read from the first parent.
+func isGenerated(commit *gitv5object.Commit, file string) (bool, error) {
+	base := path.Base(file)
+	switch {
+	case strings.HasPrefix(file, vendorDir),
+		strings.HasPrefix(file, "api/openapi-spec/"),
+		strings.HasPrefix(file, "api/discovery/"),
+		strings.HasPrefix(base, "zz_generated"),
+		strings.HasSuffix(base, ".pb.go"),
+		base == "generated.proto",
+		base == "go.sum":
+		return true, nil
+	}
+	object, err := commit.File(file)
+	if errors.Is(err, gitv5object.ErrFileNotFound) && commit.NumParents() > 0 {
+		parent, parentErr := commit.Parent(0)
+		if parentErr != nil {
+			return false, parentErr
+		}
+		object, err = parent.File(file)
+	}
+	if errors.Is(err, gitv5object.ErrFileNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	reader, err := object.Reader()
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(reader, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	return generatedHeaderRE.Match(header[:n]), nil
+}
+
+// without returns files which are not listed in excluded.
+func without(files, excluded []string) []string {
+	skip := make(map[string]bool, len(excluded))
+	for _, e := range excluded {
+		skip[e] = true
+	}
+	var result []string
+	for _, f := range files {
+		if !skip[f] {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// listFiles returns the first few files, sorted, informing about the number of the remaining ones.
+func listFiles(files []string) string {
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	if len(sorted) <= maxListedFiles {
+		return strings.Join(sorted, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(sorted[:maxListedFiles], ", "), len(sorted)-maxListedFiles)
+}
+
+var problemHeader = []string{"commit", "subject", "rule", "message"}
+
+// fields returns problem fields in the order matching problemHeader.
+func (p Problem) fields() []string {
+	rule := string(p.Rule)
+	if p.Unverified {
+		rule += " (unverified)"
+	}
+	return []string{p.Commit, p.Subject, rule, p.Message}
+}
+
+// print writes the result in the requested format.
+func (c *Lint) print(result Result) error {
+	if len(c.output) > 0 {
+		rows := make([][]string, 0, len(result.Problems))
+		for _, p := range result.Problems {
+			rows = append(rows, p.fields())
+		}
+		return carry.PrintFormatted(c.out, c.output, result, problemHeader, rows)
+	}
+	var previous string
+	for _, p := range result.Problems {
+		if p.Commit != previous {
+			if _, err := fmt.Fprintf(c.out, "%.12s %s\n", p.Commit, p.Subject); err != nil {
+				return err
+			}
+			previous = p.Commit
+		}
+		rule := p.fields()[2]
+		if _, err := fmt.Fprintf(c.out, "  %s: %s\n", rule, p.Message); err != nil {
+			return err
+		}
+	}
+	unverified := 0
+	for _, p := range result.Problems {
+		if p.Unverified {
+			unverified++
+		}
+	}
+	_, err := fmt.Fprintf(c.out, "Checked %d commits in %s: %d problems, %d of them unverified\n", result.Commits, result.Range, len(result.Problems), unverified)
+	return err
+}
@@ -0,0 +1,303 @@
+package lint
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	gitv5 "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	gitv5object "github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/github"
+	"github.com/openshift/rebase/pkg/profile"
+)
+
+// fakeGit serves commits of an in-memory repository, the files they changed
+// and upstream merges, any other call panics.
+type fakeGit struct {
+	git.Git
+	repository *gitv5.Repository
+	// changed lists files changed by a commit, indexed by its sha
+	changed map[string][]string
+	// merges holds subjects of upstream merge commits, indexed by their sha
+	merges map[string]string
+}
+
+func newFakeGit(t *testing.T) *fakeGit {
+	repository, err := gitv5.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &fakeGit{
+		repository: repository,
+		changed:    make(map[string][]string),
+		merges:     map[string]string{strings.Repeat("a", 40): "Merge pull request #101 from dev/fix"},
+	}
+}
+
+// commit writes the files and commits them with the message, returning the sha.
+func (f *fakeGit) commit(t *testing.T, message string, files map[string]string) string {
+	worktree, err := f.repository.Worktree()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for name, content := range files {
+		if err := util.WriteFile(worktree.Filesystem, name, []byte(content), 0o644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := worktree.Add(name); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash, err := worktree.Commit(message, &gitv5.CommitOptions{
+		Author:            &gitv5object.Signature{Name: "Dev", Email: "dev@x", When: time.Now()},
+		AllowEmptyCommits: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.changed[hash.String()] = names
+	return hash.String()
+}
+
+func (f *fakeGit) Commit(hash plumbing.Hash) (*gitv5object.Commit, error) {
+	return f.repository.CommitObject(hash)
+}
+
+func (f *fakeGit) ChangedFiles(commit string) ([]string, error) {
+	return f.changed[commit], nil
+}
+
+func (f *fakeGit) MergeSubjects(revision, grep string) (map[string]string, error) {
+	return f.merges, nil
+}
+
+func newProfile() *profile.Profile {
+	return &profile.Profile{
+		Downstream: profile.Repository{Remote: "openshift", Branch: "master", Owner: "openshift", Name: "kubernetes"},
+		Upstream:   profile.Repository{Remote: "upstream", Branch: "master", Owner: "kubernetes", Name: "kubernetes"},
+	}
+}
+
+// describe returns the problems as rule: message lines.
+func describe(problems []Problem) []string {
+	var result []string
+	for _, p := range problems {
+		result = append(result, p.fields()[2]+": "+p.Message)
+	}
+	return result
+}
+
+func TestLintCommit(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		files   map[string]string
+		// expected lists parts of the problems found, as rule: message
+		expected []string
+	}{
+		{
+			name:     "missing prefix",
+			message:  "fix typo",
+			files:    map[string]string{"pkg/typo.go": "package pkg"},
+			expected: []string{"prefix: Invalid carry subject \"fix typo\": missing UPSTREAM prefix"},
+		},
+		{
+			name:     "malformed prefix",
+			message:  "UPSTREAM: <keep>: add route",
+			files:    map[string]string{"pkg/route.go": "package pkg"},
+			expected: []string{"prefix: Invalid carry subject \"UPSTREAM: <keep>: add route\": unknown action <keep>"},
+		},
+		{
+			name:    "carry",
+			message: "UPSTREAM: <carry>: add route",
+			files:   map[string]string{"pkg/route.go": "package pkg\n\ntype Route struct{}"},
+		},
+		{
+			name:     "carry changing vendored code",
+			message:  "UPSTREAM: <carry>: patch dependency",
+			files:    map[string]string{"vendor/k8s.io/utils/utils.go": "package utils"},
+			expected: []string{"labels: <carry> changes vendored code, which is regenerated during rebase"},
+		},
+		{
+			name:    "carry mixing a file with the generated code header",
+			message: "UPSTREAM: <carry>: add field",
+			files: map[string]string{
+				"pkg/types.go":   "package pkg",
+				"pkg/openapi.go": "// Code generated by openapi-gen. DO NOT EDIT.\n\npackage pkg",
+			},
+			expected: []string{"mixed: mixes generated files (pkg/openapi.go) with hand-written ones (pkg/types.go), regenerate in a separate <drop> commit"},
+		},
+		{
+			name:    "pick changing go.mod along with go.sum",
+			message: "UPSTREAM: 101: bump dependencies",
+			files:   map[string]string{"go.mod": "module k8s.io/kubernetes", "go.sum": "k8s.io/utils v1.0.0 h1:x"},
+		},
+		{
+			name:    "pick mixing generated and hand-written files",
+			message: "UPSTREAM: 101: api: add field",
+			files: map[string]string{
+				"pkg/apis/core/types.go":                 "package core\n\ntype Pod struct{}",
+				"pkg/apis/core/zz_generated.deepcopy.go": "package core",
+			},
+			expected: []string{"mixed: mixes generated files (pkg/apis/core/zz_generated.deepcopy.go) with hand-written ones (pkg/apis/core/types.go), pick the hand-written changes"},
+		},
+		{
+			name:     "pick not found offline",
+			message:  "UPSTREAM: 999: fix pods",
+			files:    map[string]string{"pkg/pods.go": "package pkg"},
+			expected: []string{"upstream-pr (unverified): pull request #999 was not found in upstream/master, and could not be verified: checking GitHub is disabled"},
+		},
+		{
+			name:    "drop changing hand-written files",
+			message: "UPSTREAM: <drop>: make update",
+			files: map[string]string{
+				"pkg/update.go":                 "package pkg",
+				"api/openapi-spec/swagger.json": "{}",
+			},
+			expected: []string{"drop: <drop> changes hand-written files, which should be a <carry>: pkg/update.go"},
+		},
+		{
+			name:     "drop changing staging",
+			message:  "UPSTREAM: <drop>: patch staging",
+			files:    map[string]string{"staging/src/k8s.io/api/types.go": "package api"},
+			expected: []string{"labels: <drop> changes staging code, which should be an upstream pick or a <carry>"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := newFakeGit(t)
+			sha := repository.commit(t, test.message, test.files)
+			lint := NewLint(Options{Profile: newProfile()}, nil)
+			problems, err := lint.lintCommit(repository, sha)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertProblems(t, problems, test.expected)
+		})
+	}
+}
+
+func TestLintCommitGitHub(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path.Base(r.URL.Path) {
+		case "102":
+			fmt.Fprint(w, `{"number": 102, "merged": false}`)
+		case "103":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := github.NewClient(github.Options{BaseURL: server.URL + "/"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		// expected lists parts of the problems found, as rule: message
+		expected []string
+	}{
+		{
+			name:    "merged locally",
+			message: "UPSTREAM: 101: fix pods",
+		},
+		{
+			name:    "found on GitHub",
+			message: "UPSTREAM: 102: fix pods",
+		},
+		{
+			name:     "missing on GitHub",
+			message:  "UPSTREAM: 101, 103: fix pods",
+			expected: []string{"upstream-pr: pull request #103 does not exist in kubernetes/kubernetes"},
+		},
+		{
+			name:     "GitHub failing",
+			message:  "UPSTREAM: 104: fix pods",
+			expected: []string{"upstream-pr (unverified): pull request #104 was not found in upstream/master, and could not be verified: checking GitHub failed"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := newFakeGit(t)
+			sha := repository.commit(t, test.message, map[string]string{"pkg/pods.go": "package pkg"})
+			lint := NewLint(Options{Profile: newProfile(), GitHub: client}, nil)
+			problems, err := lint.lintCommit(repository, sha)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertProblems(t, problems, test.expected)
+		})
+	}
+}
+
+// assertProblems checks every problem contains the matching expected part.
+func assertProblems(t *testing.T, problems []Problem, expected []string) {
+	t.Helper()
+	actual := describe(problems)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected problems %q, got %q", expected, actual)
+	}
+	for i := range expected {
+		if !strings.Contains(actual[i], expected[i]) {
+			t.Errorf("expected problem %q, got %q", expected[i], actual[i])
+		}
+	}
+}
+
+func TestWithoutModuleSums(t *testing.T) {
+	tests := []struct {
+		name        string
+		generated   []string
+		handWritten []string
+		expected    []string
+	}{
+		{
+			name:        "go.sum along with its go.mod",
+			generated:   []string{"go.sum", "staging/src/k8s.io/api/go.sum"},
+			handWritten: []string{"go.mod", "staging/src/k8s.io/api/go.mod"},
+		},
+		{
+			name:        "go.sum of another module",
+			generated:   []string{"go.sum", "staging/src/k8s.io/api/go.sum"},
+			handWritten: []string{"go.mod"},
+			expected:    []string{"staging/src/k8s.io/api/go.sum"},
+		},
+		{
+			name:        "go.sum without go.mod",
+			generated:   []string{"go.sum"},
+			handWritten: []string{"pkg/kubelet/kubelet.go"},
+			expected:    []string{"go.sum"},
+		},
+		{
+			name:        "other generated files",
+			generated:   []string{"go.sum", "pkg/apis/core/zz_generated.deepcopy.go"},
+			handWritten: []string{"go.mod", "pkg/apis/core/types.go"},
+			expected:    []string{"pkg/apis/core/zz_generated.deepcopy.go"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := withoutModuleSums(test.generated, test.handWritten); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
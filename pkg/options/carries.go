@@ -0,0 +1,57 @@
+package options
+
+import (
+	"io/fs"
+
+	"github.com/spf13/pflag"
+
+	"github.com/openshift/rebase"
+	"github.com/openshift/rebase/pkg/profile"
+	"github.com/openshift/rebase/pkg/store"
+)
+
+// Carries provides the flags and options used by commands reading fixed and additional carries.
+type Carries struct {
+	// Dirs lists directories holding carries, earlier directories take precedence
+	Dirs []string
+	// Embedded adds carries shipped with the tool as the last directory
+	Embedded bool
+	// Version is the kubernetes version whose carries take precedence
+	Version string
+}
+
+func NewCarries() Carries {
+	return Carries{}
+}
+
+func (o *Carries) AddFlags(flags *pflag.FlagSet) {
+	flags.StringSliceVar(&o.Dirs, "carries-dir", o.Dirs, "Directory holding fixed and additional carries, can be repeated, earlier directories take precedence, "+
+		"defaults to carriesDirs from the profile or "+store.DefaultDir+" in the current directory")
+	flags.StringVar(&o.Version, "carries-version", o.Version, "Kubernetes version, in vMAJOR.MINOR form, whose carries take precedence over unversioned ones, "+
+		"defaults to the version of the target")
+	flags.BoolVar(&o.Embedded, "embedded-carries", o.Embedded, "Use carries shipped with the tool for the kubernetes repository, after all other carries directories")
+}
+
+// NewStore returns the carries store, directories from flags take precedence
+// over the ones from the profile.
+func (o *Carries) NewStore(profile *profile.Profile) (*store.Store, error) {
+	dirs := o.Dirs
+	if len(dirs) == 0 && profile != nil {
+		dirs = profile.CarriesDirs
+	}
+	if len(dirs) == 0 {
+		dirs = []string{store.DefaultDir}
+	}
+	if o.Embedded {
+		embedded, err := fs.Sub(rebase.Carries, "carries")
+		if err != nil {
+			return nil, err
+		}
+		dir, err := store.ExtractEmbedded(embedded)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return store.New(dirs...)
+}
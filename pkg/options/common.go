@@ -3,8 +3,12 @@ package options
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/pflag"
+
+	"github.com/openshift/rebase/pkg/carry"
+	"github.com/openshift/rebase/pkg/profile"
 )
 
 // Common provides the standard flags and options used in all commands.
@@ -14,22 +18,71 @@ type Common struct {
 	// kubernetes repository directory, as specified by the user or current working dir
 	RepositoryDir string
 
-	// kubernetes tag, from which to act on
+	// kubernetes version, from which to act on, any revision e.g. a tag, branch or sha
 	From string
+
+	// Fetch creates missing remotes and fetches them before reading carries
+	Fetch bool
+
+	// KeepDuplicate decides which of the carries with the same changes is kept
+	KeepDuplicate string
+
+	// Config is the path to the configuration file
+	Config string
+	// ProfileName is the name of the profile describing the rebased repositories
+	ProfileName string
+	// Profile is the profile loaded during Complete
+	Profile *profile.Profile
 }
 
 func NewCommon(streams IOStreams) Common {
 	return Common{
-		IOStreams: streams,
+		IOStreams:     streams,
+		KeepDuplicate: string(carry.KeepFirst),
 	}
 }
 
 func (o *Common) AddFlags(flags *pflag.FlagSet) {
+	o.AddRepositoryFlags(flags)
+	flags.StringVar(&o.From, "from", o.From, "Kubernetes starting version, any revision: an annotated or lightweight tag, branch, sha or HEAD~N")
+	o.AddFetchFlags(flags)
+	flags.StringVar(&o.KeepDuplicate, "keep-duplicate", o.KeepDuplicate, fmt.Sprintf("Which of the carries with the same changes is kept, one of: %s", strings.Join(carry.DuplicatePreferences, ", ")))
+}
+
+// AddFetchFlags adds the flag setting up and fetching the remotes.
+func (o *Common) AddFetchFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&o.Fetch, "fetch", o.Fetch, "Create missing downstream and upstream remotes, and fetch them before reading commits")
+}
+
+// AddRepositoryFlags adds flags selecting the repository and its profile,
+// for commands which do not read carries from a starting version.
+func (o *Common) AddRepositoryFlags(flags *pflag.FlagSet) {
 	flags.StringVar(&o.RepositoryDir, "repository", o.RepositoryDir, "Kubernetes repository directory, or current if none specified")
-	flags.StringVar(&o.From, "from", o.From, "Kubernetes starting version tag")
+	o.AddProfileFlags(flags)
+}
+
+// AddProfileFlags adds flags selecting the profile, for commands which do not
+// operate on the repository.
+func (o *Common) AddProfileFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.Config, "config", o.Config, fmt.Sprintf("Configuration file with additional profiles, defaults to %s", profile.DefaultConfigPath()))
+	flags.StringVar(&o.ProfileName, "profile", o.ProfileName, fmt.Sprintf("Profile describing the downstream and upstream repositories, defaults to %s", profile.DefaultProfile))
 }
 
 func (o *Common) Complete() error {
+	if err := o.CompleteRepositoryDir(); err != nil {
+		return err
+	}
+	if err := o.CompleteProfile(); err != nil {
+		return err
+	}
+	if len(o.From) == 0 {
+		return fmt.Errorf(`Error: required flag(s) "from" not set`)
+	}
+	return carry.ValidateDuplicatePreference(o.KeepDuplicate)
+}
+
+// CompleteRepositoryDir defaults repository directory to current working dir.
+func (o *Common) CompleteRepositoryDir() error {
 	if len(o.RepositoryDir) == 0 {
 		var err error
 		o.RepositoryDir, err = os.Getwd()
@@ -37,8 +90,12 @@ func (o *Common) Complete() error {
 			return err
 		}
 	}
-	if len(o.From) == 0 {
-		return fmt.Errorf(`Error: required flag(s) "from" not set`)
-	}
 	return nil
 }
+
+// CompleteProfile loads the profile from configuration.
+func (o *Common) CompleteProfile() error {
+	var err error
+	o.Profile, err = profile.Load(o.Config, o.ProfileName)
+	return err
+}
@@ -0,0 +1,108 @@
+package options
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/rebase/pkg/github"
+)
+
+// GitHub provides the flags and options used by commands talking to GitHub.
+type GitHub struct {
+	// CacheDir is the directory holding pull request state
+	CacheDir string
+	// Workers is the number of concurrent GitHub lookups
+	Workers int
+	// MaxWait is the longest wait for a GitHub rate limit reset
+	MaxWait time.Duration
+
+	// URL is the GitHub API URL
+	URL string
+	// UploadURL is the GitHub upload URL
+	UploadURL string
+	// TokenFile is the path to a file holding the token
+	TokenFile string
+	// AppID is the id of the GitHub App to authenticate as
+	AppID int64
+	// AppInstallationID is the id of the GitHub App installation
+	AppInstallationID int64
+	// AppKeyFile is the path to the GitHub App private key
+	AppKeyFile string
+}
+
+func NewGitHub() GitHub {
+	return GitHub{
+		CacheDir: github.DefaultCacheDir(),
+		Workers:  github.DefaultWorkers,
+		MaxWait:  github.DefaultMaxWait,
+	}
+}
+
+func (o *GitHub) AddFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.CacheDir, "github-cache-dir", o.CacheDir, "Directory caching the state of GitHub pull requests, empty disables the cache")
+	flags.IntVar(&o.Workers, "github-workers", o.Workers, "Number of concurrent GitHub lookups")
+	flags.DurationVar(&o.MaxWait, "github-max-wait", o.MaxWait, "Longest wait for a GitHub rate limit reset, lookups needing longer waits fail and the carry is picked, or reported unverified by lint")
+	flags.StringVar(&o.URL, "github-url", o.URL, "GitHub API URL, defaults to https://api.github.com/ or https://<host>/api/v3/ for upstream hosted elsewhere")
+	flags.StringVar(&o.UploadURL, "github-upload-url", o.UploadURL, "GitHub upload URL, defaults to https://uploads.github.com/ or https://<host>/api/uploads/ for upstream hosted elsewhere")
+	flags.StringVar(&o.TokenFile, "github-token-file", o.TokenFile, "File holding the GitHub token, by default GITHUB_TOKEN or the gh CLI token is used")
+	flags.Int64Var(&o.AppID, "github-app-id", o.AppID, "Authenticate as the GitHub App with this id, requires --github-app-installation-id and --github-app-key-file")
+	flags.Int64Var(&o.AppInstallationID, "github-app-installation-id", o.AppInstallationID, "Id of the GitHub App installation")
+	flags.StringVar(&o.AppKeyFile, "github-app-key-file", o.AppKeyFile, "File holding the PEM encoded GitHub App private key")
+}
+
+// NewClient returns a GitHub client for the given host. The credentials are
+// read from, in order: GitHub App flags, token file, GITHUB_TOKEN and the gh CLI
+// configuration.
+func (o *GitHub) NewClient(host string) (*github.Client, error) {
+	if o.Workers <= 0 {
+		return nil, fmt.Errorf("--github-workers must be positive")
+	}
+	options := github.Options{
+		BaseURL:   o.URL,
+		UploadURL: o.UploadURL,
+		CacheDir:  o.CacheDir,
+		Workers:   o.Workers,
+		MaxWait:   o.MaxWait,
+	}
+	if len(host) > 0 && host != "github.com" {
+		if len(options.BaseURL) == 0 {
+			options.BaseURL = fmt.Sprintf("https://%s/api/v3/", host)
+		}
+		if len(options.UploadURL) == 0 {
+			options.UploadURL = fmt.Sprintf("https://%s/api/uploads/", host)
+		}
+	}
+	switch {
+	case o.AppID != 0 || o.AppInstallationID != 0 || len(o.AppKeyFile) > 0:
+		if o.AppID == 0 || o.AppInstallationID == 0 || len(o.AppKeyFile) == 0 {
+			return nil, fmt.Errorf("--github-app-id, --github-app-installation-id and --github-app-key-file must be specified together")
+		}
+		klog.V(2).Infof("Authenticating as GitHub App %d", o.AppID)
+		options.App = &github.App{ID: o.AppID, InstallationID: o.AppInstallationID, PrivateKeyFile: o.AppKeyFile}
+	case len(o.TokenFile) > 0:
+		token, err := github.ReadTokenFile(o.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		options.Token = token
+	case len(os.Getenv("GITHUB_TOKEN")) > 0:
+		options.Token = os.Getenv("GITHUB_TOKEN")
+	default:
+		if len(host) == 0 {
+			host = "github.com"
+		}
+		token, err := github.GHToken(host)
+		if err != nil {
+			klog.Warningf("Reading gh CLI token failed: %v", err)
+		}
+		if len(token) > 0 {
+			klog.V(2).Infof("Using gh CLI token for %s", host)
+		}
+		options.Token = token
+	}
+	return github.NewClient(options)
+}
@@ -0,0 +1,211 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// DefaultProfile is the name of the profile used when none is specified.
+const DefaultProfile = "kubernetes"
+
+// Profile describes a downstream fork, and the upstream repository it is
+// rebased onto.
+type Profile struct {
+	Name string `yaml:"name"`
+	// Downstream is the fork carrying the patches
+	Downstream Repository `yaml:"downstream"`
+	// Upstream is the repository the fork is rebased onto
+	Upstream Repository `yaml:"upstream"`
+	// CarriesURL is the location of the fixed carries, used in log messages
+	CarriesURL string `yaml:"carriesURL"`
+	// CarriesDirs lists directories holding fixed and additional carries, earlier
+	// directories take precedence, relative paths are resolved against the
+	// configuration file directory
+	CarriesDirs []string `yaml:"carriesDirs"`
+	// RebaseMarker is the message of the merge commit which starts every rebase,
+	// defaults to merging downstream remote branch
+	RebaseMarker string `yaml:"rebaseMarker"`
+}
+
+// Repository describes a github repository, and the remote it is available under.
+type Repository struct {
+	// Remote is the name of the git remote
+	Remote string `yaml:"remote"`
+	// Host is the git hosting service, defaults to github.com
+	Host string `yaml:"host"`
+	// Owner is the organization, or user, owning the repository
+	Owner string `yaml:"owner"`
+	// Name is the name of the repository
+	Name string `yaml:"name"`
+	// Branch is the main branch of the repository
+	Branch string `yaml:"branch"`
+}
+
+// Path returns repository location in host/owner/name form.
+func (r Repository) Path() string {
+	return fmt.Sprintf("%s/%s/%s", r.Host, r.Owner, r.Name)
+}
+
+// URL returns HTTPS URL of the repository.
+func (r Repository) URL() string {
+	return fmt.Sprintf("https://%s.git", r.Path())
+}
+
+// Ref returns the remote branch in remote/branch form.
+func (r Repository) Ref() string {
+	return fmt.Sprintf("%s/%s", r.Remote, r.Branch)
+}
+
+// RemoteRef returns the full name of the remote branch.
+func (r Repository) RemoteRef() string {
+	return fmt.Sprintf("refs/remotes/%s/%s", r.Remote, r.Branch)
+}
+
+// CommitURL returns the URL of a commit in the repository.
+func (r Repository) CommitURL(sha string) string {
+	return fmt.Sprintf("https://%s/commit/%s", r.Path(), sha)
+}
+
+// FixedCarryURL returns the location of a fixed carry, used in log messages.
+func (p *Profile) FixedCarryURL(sha string) string {
+	if len(p.CarriesURL) == 0 {
+		return sha
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(p.CarriesURL, "/"), sha)
+}
+
+// builtinProfiles lists profiles available without any configuration.
+var builtinProfiles = []Profile{
+	{
+		Name: DefaultProfile,
+		Downstream: Repository{
+			Remote: "openshift",
+			Owner:  "openshift",
+			Name:   "kubernetes",
+		},
+		Upstream: Repository{
+			Remote: "upstream",
+			Owner:  "kubernetes",
+			Name:   "kubernetes",
+		},
+		CarriesURL: "https://github.com/soltysh/rebase/tree/main/carries",
+	},
+}
+
+// Config is the content of the configuration file.
+type Config struct {
+	// Profile is the name of the profile used when none is specified
+	Profile string `yaml:"profile"`
+	// Profiles lists additional profiles, they take precedence over
+	// the built-in ones with the same name
+	Profiles []Profile `yaml:"profiles"`
+}
+
+// DefaultConfigPath returns the location of the configuration file read
+// when none is specified.
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rebase", "config.yaml")
+}
+
+// Load returns the named profile, looking first in the configuration file,
+// and then in the built-in profiles. Empty configPath reads the default
+// configuration file, if it exists, and empty name picks the profile
+// from configuration, or the default one.
+func Load(configPath, name string) (*Profile, error) {
+	config := &Config{}
+	explicitConfig := len(configPath) > 0
+	if !explicitConfig {
+		configPath = DefaultConfigPath()
+	}
+	if len(configPath) > 0 {
+		data, err := os.ReadFile(configPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !explicitConfig:
+			klog.V(3).Infof("No configuration file at %s", configPath)
+		case err != nil:
+			return nil, fmt.Errorf("Error reading configuration %s: %w", configPath, err)
+		default:
+			if err := yaml.Unmarshal(data, config); err != nil {
+				return nil, fmt.Errorf("Error parsing configuration %s: %w", configPath, err)
+			}
+		}
+	}
+	if len(name) == 0 {
+		name = config.Profile
+	}
+	if len(name) == 0 {
+		name = DefaultProfile
+	}
+	for i := range config.Profiles {
+		for j, dir := range config.Profiles[i].CarriesDirs {
+			if !filepath.IsAbs(dir) {
+				config.Profiles[i].CarriesDirs[j] = filepath.Join(filepath.Dir(configPath), dir)
+			}
+		}
+	}
+	for _, profiles := range [][]Profile{config.Profiles, builtinProfiles} {
+		for i := range profiles {
+			if profiles[i].Name != name {
+				continue
+			}
+			profile := profiles[i]
+			profile.defaults()
+			if err := profile.validate(); err != nil {
+				return nil, err
+			}
+			klog.V(2).Infof("Using profile %s: %s onto %s", profile.Name, profile.Downstream.Path(), profile.Upstream.Path())
+			return &profile, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown profile %q", name)
+}
+
+// defaults fills in the optional fields.
+func (p *Profile) defaults() {
+	for _, r := range []*Repository{&p.Downstream, &p.Upstream} {
+		if len(r.Host) == 0 {
+			r.Host = "github.com"
+		}
+		if len(r.Branch) == 0 {
+			r.Branch = "master"
+		}
+	}
+	if len(p.Downstream.Remote) == 0 {
+		p.Downstream.Remote = "openshift"
+	}
+	if len(p.Upstream.Remote) == 0 {
+		p.Upstream.Remote = "upstream"
+	}
+	if len(p.RebaseMarker) == 0 {
+		p.RebaseMarker = fmt.Sprintf("Merge remote-tracking branch '%s' into", p.Downstream.Ref())
+	}
+}
+
+// validate ensures all the required fields are set.
+func (p *Profile) validate() error {
+	for _, r := range []struct {
+		kind       string
+		repository Repository
+	}{
+		{kind: "downstream", repository: p.Downstream},
+		{kind: "upstream", repository: p.Upstream},
+	} {
+		if len(r.repository.Owner) == 0 || len(r.repository.Name) == 0 {
+			return fmt.Errorf("Profile %s is missing %s repository owner or name", p.Name, r.kind)
+		}
+	}
+	if p.Downstream.Remote == p.Upstream.Remote {
+		return fmt.Errorf("Profile %s uses the same remote %s for downstream and upstream", p.Name, p.Downstream.Remote)
+	}
+	return nil
+}
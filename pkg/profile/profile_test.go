@@ -0,0 +1,144 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	kubernetes := &Profile{
+		Name:         DefaultProfile,
+		Downstream:   Repository{Remote: "openshift", Host: "github.com", Owner: "openshift", Name: "kubernetes", Branch: "master"},
+		Upstream:     Repository{Remote: "upstream", Host: "github.com", Owner: "kubernetes", Name: "kubernetes", Branch: "master"},
+		CarriesURL:   "https://github.com/soltysh/rebase/tree/main/carries",
+		RebaseMarker: "Merge remote-tracking branch 'openshift/master' into",
+	}
+
+	tests := []struct {
+		name     string
+		config   string
+		profile  string
+		expected *Profile
+		// err is a part of the expected error message
+		err string
+	}{
+		{
+			name:     "built-in profile",
+			expected: kubernetes,
+		},
+		{
+			name: "profile from the configuration",
+			config: `profile: etcd
+profiles:
+- name: etcd
+  downstream:
+    owner: openshift
+    name: etcd
+    branch: openshift-4.18
+  upstream:
+    host: gitlab.com
+    owner: etcd-io
+    name: etcd
+    branch: main
+  carriesDirs:
+  - carries
+  - /opt/carries
+`,
+			expected: &Profile{
+				Name:         "etcd",
+				Downstream:   Repository{Remote: "openshift", Host: "github.com", Owner: "openshift", Name: "etcd", Branch: "openshift-4.18"},
+				Upstream:     Repository{Remote: "upstream", Host: "gitlab.com", Owner: "etcd-io", Name: "etcd", Branch: "main"},
+				CarriesDirs:  []string{filepath.Join(dir, "carries"), "/opt/carries"},
+				RebaseMarker: "Merge remote-tracking branch 'openshift/openshift-4.18' into",
+			},
+		},
+		{
+			name: "built-in profile picked by name",
+			config: `profile: etcd
+profiles:
+- name: etcd
+  downstream: {owner: openshift, name: etcd}
+  upstream: {owner: etcd-io, name: etcd}
+`,
+			profile:  DefaultProfile,
+			expected: kubernetes,
+		},
+		{
+			name: "configuration overriding the built-in profile",
+			config: `profiles:
+- name: kubernetes
+  downstream: {remote: origin, owner: me, name: kubernetes}
+  upstream: {owner: kubernetes, name: kubernetes}
+  rebaseMarker: "Merge branch 'rebase'"
+`,
+			expected: &Profile{
+				Name:         DefaultProfile,
+				Downstream:   Repository{Remote: "origin", Host: "github.com", Owner: "me", Name: "kubernetes", Branch: "master"},
+				Upstream:     Repository{Remote: "upstream", Host: "github.com", Owner: "kubernetes", Name: "kubernetes", Branch: "master"},
+				RebaseMarker: "Merge branch 'rebase'",
+			},
+		},
+		{
+			name:    "unknown profile",
+			profile: "etcd",
+			err:     `Unknown profile "etcd"`,
+		},
+		{
+			name: "missing repository name",
+			config: `profiles:
+- name: etcd
+  downstream: {owner: openshift, name: etcd}
+  upstream: {owner: etcd-io}
+`,
+			profile: "etcd",
+			err:     "Profile etcd is missing upstream repository owner or name",
+		},
+		{
+			name: "same remotes",
+			config: `profiles:
+- name: etcd
+  downstream: {remote: origin, owner: openshift, name: etcd}
+  upstream: {remote: origin, owner: etcd-io, name: etcd}
+`,
+			profile: "etcd",
+			err:     "Profile etcd uses the same remote origin for downstream and upstream",
+		},
+		{
+			name:   "malformed configuration",
+			config: "profiles: {",
+			err:    "Error parsing configuration",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, "config.yaml")
+			if err := os.WriteFile(path, []byte(test.config), 0o644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			profile, err := Load(path, test.profile)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(profile, test.expected) {
+				t.Errorf("expected profile %#v, got %#v", test.expected, profile)
+			}
+		})
+	}
+}
+
+func TestLoadMissingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if _, err := Load(path, ""); err == nil || !strings.Contains(err.Error(), "Error reading configuration") {
+		t.Errorf("expected error reading the configuration, got %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFile is the name of the optional manifest describing carries of
+// a directory, or of its version subdirectory.
+const ManifestFile = "manifest.yaml"
+
+// Action describes what to do with a carry described in the manifest
+type Action string
+
+const (
+	// ActionApply applies the fixed carry patch when the carry does not
+	// pick cleanly, same as a non-empty fixed carry without a manifest entry
+	ActionApply Action = "apply"
+	// ActionSkip skips the carry when it does not pick cleanly, same as
+	// an empty fixed carry without a manifest entry
+	ActionSkip Action = "skip"
+	// ActionDrop drops the carry without picking it
+	ActionDrop Action = "drop"
+	// ActionReplace applies the fixed carry patch instead of picking the carry
+	ActionReplace Action = "replace"
+)
+
+// Entry describes a fixed or an additional carry.
+type Entry struct {
+	// Name is the sha of the original carry, or the file name of an additional carry
+	Name string `yaml:"name,omitempty"`
+	// Action is what to do with the carry, defaults to apply
+	Action Action `yaml:"action,omitempty"`
+	// Patch is the patch file, relative to the manifest, defaults to the name
+	// of a fixed carry, or to the additional carry of that name
+	Patch string `yaml:"patch,omitempty"`
+	// Reason explains why the entry exists
+	Reason string `yaml:"reason,omitempty"`
+	// Owner is who to ask about the entry
+	Owner string `yaml:"owner,omitempty"`
+	// Issue links the issue tracking the entry
+	Issue string `yaml:"issue,omitempty"`
+	// Expires is the kubernetes version, in vMAJOR.MINOR form, from which
+	// the entry is ignored
+	Expires string `yaml:"expires,omitempty"`
+	// Manifest is the path to the manifest describing the entry, empty for
+	// carries without a manifest entry
+	Manifest string `yaml:"-"`
+}
+
+// Manifest describes carries of a single directory.
+type Manifest struct {
+	// Fixed describes fixed carries, indexed by the sha of the original carry
+	Fixed map[string]*Entry `yaml:"fixed,omitempty"`
+	// Additional describes additional carries, in the order they are applied,
+	// additional carries missing from the list are applied after the listed
+	// ones, sorted by their name
+	Additional []*Entry `yaml:"additional,omitempty"`
+}
+
+// Describe returns a human readable summary of the entry metadata.
+func (e *Entry) Describe() string {
+	description := string(e.Action)
+	if len(e.Reason) > 0 {
+		description += fmt.Sprintf(": %s", e.Reason)
+	}
+	if len(e.Owner) > 0 {
+		description += fmt.Sprintf(", owner %s", e.Owner)
+	}
+	if len(e.Issue) > 0 {
+		description += fmt.Sprintf(", issue %s", e.Issue)
+	}
+	return description
+}
+
+// Expired returns true when the entry does not apply to the version, entries
+// always apply to an unknown version.
+func (e *Entry) Expired(version string) bool {
+	if len(e.Expires) == 0 || len(version) == 0 {
+		return false
+	}
+	return compareVersions(version, e.Expires) >= 0
+}
+
+// readManifest reads the manifest of a directory, returning an empty one
+// when there's none.
+func readManifest(dir string) (*Manifest, error) {
+	path := filepath.Join(dir, ManifestFile)
+	manifest := &Manifest{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %w", path, err)
+	}
+	for sha, entry := range manifest.Fixed {
+		if entry == nil {
+			entry = &Entry{}
+			manifest.Fixed[sha] = entry
+		}
+		entry.Name = sha
+		if err := entry.complete(path, ActionApply, ActionSkip, ActionDrop, ActionReplace); err != nil {
+			return nil, err
+		}
+		if len(entry.Patch) == 0 {
+			entry.Patch = filepath.Join(dir, sha)
+		}
+	}
+	for _, entry := range manifest.Additional {
+		if entry == nil || len(entry.Name) == 0 {
+			return nil, fmt.Errorf("Additional carry in %s is missing a name", path)
+		}
+		if err := entry.complete(path, ActionApply, ActionSkip); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// complete defaults and validates the entry read from the manifest at path.
+func (e *Entry) complete(path string, actions ...Action) error {
+	e.Manifest = path
+	if len(e.Action) == 0 {
+		e.Action = ActionApply
+	}
+	valid := false
+	for _, action := range actions {
+		valid = valid || e.Action == action
+	}
+	if !valid {
+		return fmt.Errorf("Invalid action %q of %s in %s, expected one of %v", e.Action, e.Name, path, actions)
+	}
+	if len(e.Patch) > 0 {
+		e.Patch = filepath.Join(filepath.Dir(path), e.Patch)
+	}
+	if len(e.Expires) > 0 {
+		version, ok := Version(e.Expires)
+		if !ok {
+			return fmt.Errorf("Invalid expiry %q of %s in %s", e.Expires, e.Name, path)
+		}
+		e.Expires = version
+	}
+	return nil
+}
+
+// compareVersions compares two vMAJOR.MINOR versions, returning a negative
+// number, zero or a positive number when a is lower, equal or greater than b.
+func compareVersions(a, b string) int {
+	aMatch := versionRE.FindStringSubmatch(a)
+	bMatch := versionRE.FindStringSubmatch(b)
+	if aMatch == nil || bMatch == nil {
+		return 0
+	}
+	for i := 1; i <= 2; i++ {
+		aNumber, _ := strconv.Atoi(aMatch[i])
+		bNumber, _ := strconv.Atoi(bMatch[i])
+		if aNumber != bNumber {
+			return aNumber - bNumber
+		}
+	}
+	return 0
+}
+
+// manifestDocument is the manifest of a directory read as a YAML document,
+// so that fixed carries can be added and removed without losing comments,
+// or the fields this tool does not know about.
+type manifestDocument struct {
+	path string
+	doc  *yaml.Node
+	// fixed is the mapping of fixed carries, nil when there's none
+	fixed   *yaml.Node
+	changed bool
+}
+
+// readManifestDocument reads the manifest of a directory, returning an empty
+// one when there's none.
+func readManifestDocument(dir string) (*manifestDocument, error) {
+	m := &manifestDocument{path: filepath.Join(dir, ManifestFile), doc: &yaml.Node{}}
+	data, err := os.ReadFile(m.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, m.doc); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %w", m.path, err)
+	}
+	if m.doc.Kind == 0 {
+		m.doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if m.doc.Kind != yaml.DocumentNode || len(m.doc.Content) != 1 || m.doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("Error parsing %s: expected a mapping", m.path)
+	}
+	if _, fixed := mappingEntry(m.doc.Content[0], "fixed"); fixed != nil && fixed.Kind == yaml.MappingNode {
+		m.fixed = fixed
+	}
+	return m, nil
+}
+
+// mappingEntry returns the key and the value of the mapping entry, nils when
+// there's none.
+func mappingEntry(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// fixedEntry returns the key and the value describing the fixed carry, nils
+// when there's none.
+func (m *manifestDocument) fixedEntry(sha string) (*yaml.Node, *yaml.Node) {
+	if m.fixed == nil {
+		return nil, nil
+	}
+	return mappingEntry(m.fixed, sha)
+}
+
+// setFixedEntry adds, or replaces, the entry describing the fixed carry.
+func (m *manifestDocument) setFixedEntry(key, value *yaml.Node) {
+	m.changed = true
+	if m.fixed == nil {
+		m.fixed = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root := m.doc.Content[0]
+		if fixedKey, _ := mappingEntry(root, "fixed"); fixedKey != nil {
+			// fixed carries were listed as null
+			for i := 0; i < len(root.Content); i += 2 {
+				if root.Content[i] == fixedKey {
+					root.Content[i+1] = m.fixed
+				}
+			}
+		} else {
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "fixed"}, m.fixed)
+		}
+	}
+	for i := 0; i+1 < len(m.fixed.Content); i += 2 {
+		if m.fixed.Content[i].Value == key.Value {
+			m.fixed.Content[i], m.fixed.Content[i+1] = key, value
+			return
+		}
+	}
+	m.fixed.Content = append(m.fixed.Content, key, value)
+}
+
+// removeFixedEntry removes the entry describing the fixed carry, along with
+// the mapping of fixed carries, when it's left empty.
+func (m *manifestDocument) removeFixedEntry(sha string) {
+	if m.fixed == nil {
+		return
+	}
+	for i := 0; i+1 < len(m.fixed.Content); i += 2 {
+		if m.fixed.Content[i].Value == sha {
+			m.fixed.Content = append(m.fixed.Content[:i], m.fixed.Content[i+2:]...)
+			m.changed = true
+			break
+		}
+	}
+	if len(m.fixed.Content) > 0 {
+		return
+	}
+	// drop the mapping of fixed carries left empty
+	root := m.doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i+1] == m.fixed {
+			// keep comments preceding the mapping, with the following key
+			if comment := root.Content[i].HeadComment; len(comment) > 0 && i+2 < len(root.Content) {
+				next := root.Content[i+2]
+				next.HeadComment = strings.TrimSpace(comment + "\n\n" + next.HeadComment)
+			}
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+			m.fixed = nil
+			return
+		}
+	}
+}
+
+// write writes the manifest back, when it was changed.
+func (m *manifestDocument) write() error {
+	if !m.changed {
+		return nil
+	}
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(m.doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, buffer.Bytes(), 0o644)
+}
+
+// withPatch returns a copy of the entry with the patch replaced.
+func withPatch(entry *yaml.Node, patch string) *yaml.Node {
+	result := *entry
+	if result.Kind != yaml.MappingNode {
+		// an entry without any fields
+		result = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	result.Content = append([]*yaml.Node(nil), result.Content...)
+	for i := 0; i+1 < len(result.Content); i += 2 {
+		if result.Content[i].Value == "patch" {
+			result.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: patch}
+			return &result
+		}
+	}
+	result.Content = append(result.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "patch"},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: patch})
+	return &result
+}
@@ -0,0 +1,554 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/rebase/pkg/git"
+)
+
+const (
+	// DefaultDir is the carries directory used when none is specified,
+	// relative to the current working directory
+	DefaultDir = "carries"
+	// additionalDir is the directory, inside the store, holding additional carries
+	additionalDir = "additional"
+)
+
+// Store holds fixed carries, named after the sha of the original carry commit,
+// and additional carries applied after all other carries. The store is
+// a stack of directories, earlier directories take precedence over later ones,
+// missing directories are treated as empty.
+type Store struct {
+	dirs []string
+	// version is the kubernetes version, in vMAJOR.MINOR form, whose
+	// subdirectories take precedence over unversioned entries
+	version string
+}
+
+// versionRE matches kubernetes versions, capturing major and minor
+var versionRE = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:[.-]|$)`)
+
+// Version returns the vMAJOR.MINOR version directory name for a kubernetes
+// version, tag or branch, eg. v1.31 for v1.31.0 or release-1.31.
+func Version(ref string) (string, bool) {
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	match := versionRE.FindStringSubmatch(strings.TrimPrefix(ref, "release-"))
+	if match == nil {
+		return "", false
+	}
+	return fmt.Sprintf("v%s.%s", match[1], match[2]), true
+}
+
+// New returns a store kept in dirs, relative paths are resolved against
+// the current working directory.
+func New(dirs ...string) (*Store, error) {
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("No carries directory specified")
+	}
+	s := &Store{}
+	for _, dir := range dirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		s.dirs = append(s.dirs, absDir)
+	}
+	klog.V(2).Infof("Using carries from %v", s.dirs)
+	return s, nil
+}
+
+// RevisionVersion returns the version of a revision, based on its name,
+// or on the closest tag, when the name is not a version.
+func RevisionVersion(repository git.Git, revision string) (string, error) {
+	if version, ok := Version(revision); ok {
+		return version, nil
+	}
+	tag, err := repository.Describe(revision)
+	if err != nil {
+		return "", fmt.Errorf("Error finding version of %s: %w", revision, err)
+	}
+	if version, ok := Version(tag); ok {
+		return version, nil
+	}
+	return "", fmt.Errorf("Tag %s of %s is not a version", tag, revision)
+}
+
+// ForVersion returns the store preferring carries of a given version,
+// empty version uses unversioned carries only.
+func (s *Store) ForVersion(version string) *Store {
+	if len(version) > 0 {
+		klog.V(2).Infof("Using carries for %s", version)
+	}
+	return &Store{dirs: s.dirs, version: version}
+}
+
+// Version returns the version whose carries take precedence.
+func (s *Store) Version() string {
+	return s.version
+}
+
+// Dir returns the directory new carries are written to, the first one.
+func (s *Store) Dir() string {
+	return s.dirs[0]
+}
+
+// searchDirs returns the directories to look for carries in, the versioned
+// one before the unversioned one, for every directory of the store.
+func (s *Store) searchDirs() []string {
+	var dirs []string
+	for _, dir := range s.dirs {
+		if len(s.version) > 0 {
+			dirs = append(dirs, filepath.Join(dir, s.version))
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// writeDir returns the directory new carries are written to, the versioned
+// subdirectory of the first directory, if the version is set.
+func (s *Store) writeDir() string {
+	if len(s.version) > 0 {
+		return filepath.Join(s.Dir(), s.version)
+	}
+	return s.Dir()
+}
+
+// Dirs returns all the directories, in the order of precedence.
+func (s *Store) Dirs() []string {
+	return s.dirs
+}
+
+// FixedCarry looks for fixed carry of the original carry commit sha. Manifest
+// entries take precedence over bare files of the same directory, expired
+// entries are ignored. A bare file is applied, unless it's empty, which means
+// the carry is skipped. Returns an error wrapping os.ErrNotExist, when there's
+// no fixed carry.
+func (s *Store) FixedCarry(sha string) (*Entry, error) {
+	for _, dir := range s.searchDirs() {
+		manifest, err := readManifest(dir)
+		if err != nil {
+			return nil, err
+		}
+		if entry, ok := manifest.Fixed[sha]; ok {
+			if !entry.Expired(s.version) {
+				return entry, nil
+			}
+			klog.Warningf("Fixed carry %s in %s expired in %s, ignoring it", sha, dir, entry.Expires)
+			continue
+		}
+		carryPath := filepath.Join(dir, sha)
+		fileInfo, err := os.Stat(carryPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// empty fixed carry informs the patch was mislabeled
+		entry := &Entry{Name: sha, Action: ActionApply, Patch: carryPath}
+		if fileInfo.Size() == 0 {
+			entry.Action = ActionSkip
+		}
+		return entry, nil
+	}
+	return nil, fmt.Errorf("No fixed carry for %s: %w", sha, os.ErrNotExist)
+}
+
+// AdditionalCarries looks for additional carry patches which need to be applied.
+// Patches with the same name in earlier directories, or in the versioned
+// subdirectory, hide the other ones. Patches are ordered as listed in the
+// manifests, earlier directories first, followed by the unlisted ones sorted
+// by their name, patches skipped or expired in the manifest are left out.
+// Returns a list of files containing the carries, and error.
+func (s *Store) AdditionalCarries() ([]string, error) {
+	carries := make(map[string]string)
+	entries := make(map[string]*Entry)
+	var listed []string
+	dirs := s.searchDirs()
+	for i := len(dirs) - 1; i >= 0; i-- {
+		additionalPath := filepath.Join(dirs[i], additionalDir)
+		manifest, err := readManifest(dirs[i])
+		if err != nil {
+			return nil, err
+		}
+		// names listed by earlier directories go first
+		var order []string
+		for _, entry := range manifest.Additional {
+			if !contains(order, entry.Name) {
+				order = append(order, entry.Name)
+			}
+			entries[entry.Name] = entry
+		}
+		for _, name := range listed {
+			if _, ok := entries[name]; ok && !contains(order, name) {
+				order = append(order, name)
+			}
+		}
+		listed = order
+		files, err := os.ReadDir(additionalPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			carries[file.Name()] = filepath.Join(additionalPath, file.Name())
+		}
+	}
+	var unlisted []string
+	for name := range carries {
+		if _, ok := entries[name]; !ok {
+			unlisted = append(unlisted, name)
+		}
+	}
+	sort.Strings(unlisted)
+	additionalCarries := []string{}
+	for _, name := range listed {
+		entry := entries[name]
+		switch {
+		case entry.Expired(s.version):
+			klog.Warningf("Additional carry %s expired in %s, ignoring it", name, entry.Expires)
+		case entry.Action == ActionSkip:
+			klog.Infof("Skipping additional carry %s - %s", name, entry.Describe())
+		case len(entry.Patch) > 0:
+			additionalCarries = append(additionalCarries, entry.Patch)
+		case len(carries[name]) > 0:
+			additionalCarries = append(additionalCarries, carries[name])
+		default:
+			return nil, fmt.Errorf("Additional carry %s listed in the manifest does not exist", name)
+		}
+	}
+	for _, name := range unlisted {
+		additionalCarries = append(additionalCarries, carries[name])
+	}
+	return additionalCarries, nil
+}
+
+// Contents lists carries of a single directory of the store.
+type Contents struct {
+	// Dir is the directory
+	Dir string
+	// Fixed lists fixed carries, described in the manifest or bare files
+	Fixed []*Entry
+	// Additional lists additional carries, described in the manifest or bare files
+	Additional []*Entry
+	// Unknown lists files which are neither carries, nor referenced by the manifest
+	Unknown []string
+}
+
+// Contents lists carries of every directory the store looks for carries in,
+// the version subdirectory before the directory, in the order of precedence.
+// Additional carries listed in the manifest without a patch in the same
+// directory have an empty Patch.
+func (s *Store) Contents() ([]*Contents, error) {
+	var contents []*Contents
+	for _, dir := range s.searchDirs() {
+		manifest, err := readManifest(dir)
+		if err != nil {
+			return nil, err
+		}
+		c := &Contents{Dir: dir}
+		referenced := map[string]bool{filepath.Join(dir, ManifestFile): true}
+		shas := make([]string, 0, len(manifest.Fixed))
+		for sha := range manifest.Fixed {
+			shas = append(shas, sha)
+		}
+		sort.Strings(shas)
+		for _, sha := range shas {
+			c.Fixed = append(c.Fixed, manifest.Fixed[sha])
+			referenced[manifest.Fixed[sha].Patch] = true
+		}
+		files, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			switch {
+			case file.IsDir() || referenced[path]:
+			case shaRE.MatchString(file.Name()):
+				if _, ok := manifest.Fixed[file.Name()]; ok {
+					// the manifest entry uses a different patch
+					c.Unknown = append(c.Unknown, path)
+					continue
+				}
+				entry := &Entry{Name: file.Name(), Action: ActionApply, Patch: path}
+				if info, err := file.Info(); err == nil && info.Size() == 0 {
+					entry.Action = ActionSkip
+				}
+				c.Fixed = append(c.Fixed, entry)
+			default:
+				c.Unknown = append(c.Unknown, path)
+			}
+		}
+
+		additionalPath := filepath.Join(dir, additionalDir)
+		files, err = os.ReadDir(additionalPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, entry := range manifest.Additional {
+			if len(entry.Patch) == 0 {
+				if _, err := os.Stat(filepath.Join(additionalPath, entry.Name)); err == nil {
+					entry.Patch = filepath.Join(additionalPath, entry.Name)
+				}
+			}
+			c.Additional = append(c.Additional, entry)
+			referenced[entry.Patch] = true
+		}
+		for _, file := range files {
+			path := filepath.Join(additionalPath, file.Name())
+			if file.IsDir() || referenced[path] {
+				continue
+			}
+			c.Additional = append(c.Additional, &Entry{Name: file.Name(), Action: ActionApply, Patch: path})
+		}
+		// a patch referenced by the manifest of the additional carries only
+		for i := 0; i < len(c.Unknown); i++ {
+			if referenced[c.Unknown[i]] {
+				c.Unknown = append(c.Unknown[:i], c.Unknown[i+1:]...)
+				i--
+			}
+		}
+		contents = append(contents, c)
+	}
+	return contents, nil
+}
+
+// contains returns true when the name is one of the names.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteFixedCarry stores the patch as the fixed carry of the original carry
+// commit sha, in the first directory, under the version subdirectory if set.
+// Existing fixed carry is replaced only when overwrite is set.
+func (s *Store) WriteFixedCarry(sha string, patch []byte, overwrite bool) (string, error) {
+	carryPath := filepath.Join(s.writeDir(), sha)
+	if _, err := os.Stat(carryPath); err == nil && !overwrite {
+		return "", fmt.Errorf("Fixed carry %s already exists", carryPath)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if err := os.MkdirAll(s.writeDir(), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(carryPath, patch, 0o644); err != nil {
+		return "", err
+	}
+	return carryPath, nil
+}
+
+// Promote copies fixed carries of the from version into the to version
+// subdirectory of the first directory, empty from version takes unversioned
+// carries. Manifest entries are promoted into the manifest of the to version,
+// along with their patches kept in the from version directory. Only listed
+// shas are promoted, or all when none are listed. Unless keep is set, promoted
+// carries are removed from the from version. Returns the shas of promoted
+// carries.
+func (s *Store) Promote(from, to string, shas []string, keep, overwrite bool) ([]string, error) {
+	fromDir := filepath.Join(s.Dir(), from)
+	toDir := filepath.Join(s.Dir(), to)
+	if fromDir == toDir {
+		return nil, fmt.Errorf("Cannot promote carries of %s onto themselves", fromDir)
+	}
+	fromManifest, err := readManifest(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	toManifest, err := readManifest(toDir)
+	if err != nil {
+		return nil, err
+	}
+	fromDocument, err := readManifestDocument(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	toDocument, err := readManifestDocument(toDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(shas) == 0 {
+		files, err := os.ReadDir(fromDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, file := range files {
+			if !file.IsDir() && shaRE.MatchString(file.Name()) {
+				shas = append(shas, file.Name())
+			}
+		}
+		for sha := range fromManifest.Fixed {
+			if !contains(shas, sha) {
+				shas = append(shas, sha)
+			}
+		}
+		sort.Strings(shas)
+	}
+	if err := os.MkdirAll(toDir, 0o755); err != nil {
+		return nil, err
+	}
+	var promoted []string
+	for _, sha := range shas {
+		entry, listed := fromManifest.Fixed[sha]
+		source := filepath.Join(fromDir, sha)
+		if listed {
+			source = entry.Patch
+		}
+		// patches outside of the from version directory are referenced, not copied
+		relative, err := filepath.Rel(fromDir, source)
+		if err != nil {
+			return promoted, err
+		}
+		copied := relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+		target := filepath.Join(toDir, relative)
+		if _, ok := toManifest.Fixed[sha]; ok && !overwrite {
+			klog.Warningf("Fixed carry %s already exists in %s, skipping", sha, toDocument.path)
+			continue
+		}
+		if _, err := os.Stat(target); copied && err == nil && !overwrite {
+			klog.Warningf("Fixed carry %s already exists, skipping", target)
+			continue
+		}
+		data, err := os.ReadFile(source)
+		exists := err == nil
+		switch {
+		case listed && errors.Is(err, os.ErrNotExist):
+			// entries skipping or dropping the carry need no patch
+			copied = false
+		case err != nil:
+			return promoted, fmt.Errorf("Error reading fixed carry: %w", err)
+		}
+		if copied {
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return promoted, err
+			}
+			if err := os.WriteFile(target, data, 0o644); err != nil {
+				return promoted, err
+			}
+		}
+		if listed {
+			key, value := fromDocument.fixedEntry(sha)
+			if !copied && exists {
+				patch, err := filepath.Rel(toDir, source)
+				if err != nil {
+					return promoted, err
+				}
+				value = withPatch(value, filepath.ToSlash(patch))
+			}
+			toDocument.setFixedEntry(key, value)
+			if err := toDocument.write(); err != nil {
+				return promoted, err
+			}
+		}
+		if !keep {
+			if listed {
+				fromDocument.removeFixedEntry(sha)
+				if err := fromDocument.write(); err != nil {
+					return promoted, err
+				}
+			}
+			if copied {
+				if err := os.Remove(source); err != nil {
+					return promoted, err
+				}
+			}
+		}
+		klog.V(2).Infof("Promoted %s to %s", sha, toDir)
+		promoted = append(promoted, sha)
+	}
+	return promoted, nil
+}
+
+// shaRE matches names of fixed carries
+var shaRE = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ExtractEmbedded copies carries embedded in the binary into the cache
+// directory, so they can be applied, and returns their location. The location
+// depends on the content, so it stays valid across invocations, which is
+// required to resume an apply.
+func ExtractEmbedded(fsys fs.FS) (string, error) {
+	hash := sha256.New()
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		file, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		fmt.Fprintf(hash, "%s\x00", path)
+		_, err = io.Copy(hash, file)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error reading embedded carries: %w", err)
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, "rebase", "carries", hex.EncodeToString(hash.Sum(nil))[:16])
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	klog.V(2).Infof("Extracting embedded carries into %s", dir)
+	// extract into a temporary directory first, so that interrupted extraction is never used
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return "", err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+".")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	err = fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(tmpDir, filepath.FromSlash(path))
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o644)
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error extracting embedded carries: %w", err)
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		// another invocation might have extracted the carries in the meantime
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
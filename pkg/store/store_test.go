@@ -0,0 +1,168 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestPromote(t *testing.T) {
+	listed := strings.Repeat("a", 40)
+	named := strings.Repeat("b", 40)
+	shared := strings.Repeat("c", 40)
+	skipped := strings.Repeat("d", 40)
+	bare := strings.Repeat("e", 40)
+	manifest := `# carries of openshift
+fixed:
+  # conflicts with upstream
+  ` + listed + `:
+    owner: me
+  ` + named + `:
+    patch: patches/named.patch
+  ` + shared + `:
+    patch: ../shared/c.patch
+  ` + skipped + `:
+    action: skip
+additional:
+  - name: extra.patch
+`
+
+	tests := []struct {
+		name string
+		keep bool
+	}{
+		{name: "move"},
+		{name: "keep", keep: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "carries")
+			writeFiles(t, root, map[string]string{
+				"carries/" + ManifestFile:                  manifest,
+				"carries/" + listed:                        "listed",
+				"carries/patches/named.patch":              "named",
+				"carries/" + bare:                          "bare",
+				"shared/c.patch":                           "shared",
+				"carries/additional/extra.patch":           "extra",
+				"carries/v1.30/" + strings.Repeat("f", 40): "older",
+			})
+			s, err := New(dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			promoted, err := s.Promote("", "v1.31", nil, test.keep, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := []string{listed, named, shared, skipped, bare}; strings.Join(promoted, ",") != strings.Join(expected, ",") {
+				t.Errorf("expected promoted %v, got %v", expected, promoted)
+			}
+
+			versioned := s.ForVersion("v1.31")
+			for sha, patch := range map[string]string{
+				listed: filepath.Join(dir, "v1.31", listed),
+				named:  filepath.Join(dir, "v1.31", "patches", "named.patch"),
+				shared: filepath.Join(root, "shared", "c.patch"),
+				bare:   filepath.Join(dir, "v1.31", bare),
+			} {
+				entry, err := versioned.FixedCarry(sha)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if entry.Patch != patch || entry.Action != ActionApply {
+					t.Errorf("unexpected fixed carry %s: %#v", sha, entry)
+				}
+				if _, err := os.Stat(patch); err != nil {
+					t.Errorf("missing patch of %s: %v", sha, err)
+				}
+			}
+			entry, err := versioned.FixedCarry(skipped)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if entry.Action != ActionSkip || !strings.HasPrefix(entry.Manifest, filepath.Join(dir, "v1.31")) {
+				t.Errorf("unexpected fixed carry %s: %#v", skipped, entry)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "v1.31", ManifestFile))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(string(data), "# conflicts with upstream") || !strings.Contains(string(data), "owner: me") {
+				t.Errorf("promoted manifest lost entry details:\n%s", data)
+			}
+
+			data, err = os.ReadFile(filepath.Join(dir, ManifestFile))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.keep != strings.Contains(string(data), listed) {
+				t.Errorf("unexpected source manifest:\n%s", data)
+			}
+			if !strings.Contains(string(data), "# carries of openshift") || !strings.Contains(string(data), "extra.patch") {
+				t.Errorf("source manifest lost other entries:\n%s", data)
+			}
+			_, err = os.Stat(filepath.Join(dir, "patches", "named.patch"))
+			if test.keep != (err == nil) {
+				t.Errorf("unexpected source patch: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "shared", "c.patch")); err != nil {
+				t.Errorf("patch outside of the version was removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestPromoteExisting(t *testing.T) {
+	sha := strings.Repeat("a", 40)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		ManifestFile:                         "fixed:\n  " + sha + ":\n    reason: newer\n",
+		sha:                                  "newer",
+		filepath.Join("v1.31", sha):          "existing",
+		filepath.Join("v1.31", ManifestFile): "fixed:\n  " + sha + ":\n    reason: existing\n",
+	})
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	promoted, err := s.Promote("", "v1.31", nil, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(promoted) != 0 {
+		t.Errorf("expected nothing promoted, got %v", promoted)
+	}
+
+	promoted, err = s.Promote("", "v1.31", nil, false, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(promoted) != 1 {
+		t.Errorf("expected %s promoted, got %v", sha, promoted)
+	}
+	entry, err := s.ForVersion("v1.31").FixedCarry(sha)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entry.Reason != "newer" {
+		t.Errorf("expected the entry to be overwritten, got %#v", entry)
+	}
+	if data, err := os.ReadFile(entry.Patch); err != nil || string(data) != "newer" {
+		t.Errorf("expected the patch to be overwritten, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,195 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/github"
+	"github.com/openshift/rebase/pkg/profile"
+)
+
+// mergePullRequestRE matches subjects of upstream pull request merge commits
+var mergePullRequestRE = regexp.MustCompile(`^Merge pull request #(\d+) from `)
+
+// Resolver determines whether upstream pull requests were merged, based on
+// the locally fetched upstream history, falling back to GitHub.
+type Resolver struct {
+	repository git.Git
+	profile    *profile.Profile
+	client     *github.Client
+	from       string
+	target     string
+
+	loaded bool
+	// pullRequests holds merge commits of upstream pull requests, indexed by number
+	pullRequests map[int]string
+	// patchIDs holds upstream commits between from and target, indexed by patch id
+	patchIDs map[string]string
+	// commitPatchIDs holds patch ids of already checked picks, indexed by their sha
+	commitPatchIDs map[string]string
+	// remote holds pull requests read from GitHub in advance, indexed by number
+	remote map[int]*github.PullRequest
+}
+
+// NewResolver returns a resolver looking for pull requests merged into target,
+// and for commits equivalent to the picks added between from and target.
+// Resolver without a client never asks GitHub.
+func NewResolver(repository git.Git, profile *profile.Profile, client *github.Client, from, target string) *Resolver {
+	return &Resolver{
+		repository:     repository,
+		profile:        profile,
+		client:         client,
+		from:           from,
+		target:         target,
+		commitPatchIDs: make(map[string]string),
+		remote:         make(map[int]*github.PullRequest),
+	}
+}
+
+// Prefetch reads from GitHub, concurrently, all the picks which are not found
+// in the local upstream history. Picks maps commit sha to the picked pull requests.
+func (r *Resolver) Prefetch(picks map[string][]int) error {
+	if r.client == nil || len(picks) == 0 {
+		return nil
+	}
+	if err := r.load(); err != nil {
+		return err
+	}
+	var numbers []int
+	for sha, pickNumbers := range picks {
+		for _, number := range pickNumbers {
+			_, _, found, err := r.local(sha, number)
+			if err != nil {
+				return err
+			}
+			if !found {
+				numbers = append(numbers, number)
+			}
+		}
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+	klog.V(2).Infof("Reading %d pull requests from GitHub...", len(numbers))
+	pullRequests, err := r.client.PullRequests(context.Background(), r.profile.Upstream.Owner, r.profile.Upstream.Name, numbers)
+	if err != nil {
+		klog.Warningf("Reading pull requests from GitHub failed: %v", err)
+	}
+	for number, pullRequest := range pullRequests {
+		r.remote[number] = pullRequest
+	}
+	return nil
+}
+
+// IsMerged returns information whether the upstream pull request, picked
+// in commit sha, was merged upstream before the target, along with the reason
+// for the decision.
+func (r *Resolver) IsMerged(sha string, number int) (bool, string, error) {
+	if err := r.load(); err != nil {
+		return false, "", err
+	}
+	merged, reason, found, err := r.local(sha, number)
+	if err != nil || found {
+		return merged, reason, err
+	}
+	if r.client == nil {
+		return false, fmt.Sprintf("pull request #%d was not found in %.12s", number, r.target), nil
+	}
+	pullRequest, ok := r.remote[number]
+	if !ok {
+		klog.V(2).Infof("Pull request #%d was not found upstream, checking GitHub...", number)
+		pullRequest, err = r.client.PullRequest(context.Background(), r.profile.Upstream.Owner, r.profile.Upstream.Name, number)
+		if err != nil {
+			klog.Warningf("Checking pull request #%d on GitHub failed: %v", number, err)
+			return false, fmt.Sprintf("pull request #%d was not found in %.12s, and checking GitHub failed", number, r.target), nil
+		}
+		r.remote[number] = pullRequest
+	}
+	if !pullRequest.Merged {
+		return false, fmt.Sprintf("pull request #%d is not merged according to GitHub", number), nil
+	}
+	merge := pullRequest.MergeCommitSHA
+	ancestor, err := r.repository.IsAncestor(merge, r.target)
+	if err != nil {
+		// the merge commit is missing locally, most likely it was merged after the target was fetched
+		klog.V(2).Infof("Checking whether %s is an ancestor of %s failed: %v", merge, r.target, err)
+		return false, fmt.Sprintf("pull request #%d was merged in %.12s, which is not available locally", number, merge), nil
+	}
+	if !ancestor {
+		return false, fmt.Sprintf("pull request #%d was merged in %.12s, which is not part of %.12s", number, merge, r.target), nil
+	}
+	return true, fmt.Sprintf("pull request #%d was merged in %.12s, which is part of %.12s", number, merge, r.target), nil
+}
+
+// local looks for the pull request, and for a commit equivalent to the pick,
+// in the local upstream history. Returns whether it was merged, the reason,
+// and whether the local history was sufficient to decide.
+func (r *Resolver) local(sha string, number int) (bool, string, bool, error) {
+	if merge, ok := r.pullRequests[number]; ok {
+		return true, fmt.Sprintf("pull request #%d was merged in %.12s, which is part of %.12s", number, merge, r.target), true, nil
+	}
+	patchID, ok := r.commitPatchIDs[sha]
+	if !ok {
+		patchIDs, err := r.repository.PatchIDs(sha + "^!")
+		if err != nil {
+			return false, "", false, fmt.Errorf("Error computing patch id of %s: %w", sha, err)
+		}
+		patchID = patchIDs[sha]
+		r.commitPatchIDs[sha] = patchID
+	}
+	if equivalent, ok := r.patchIDs[patchID]; ok && len(patchID) > 0 {
+		return true, fmt.Sprintf("upstream commit %.12s, which is part of %.12s, is equivalent", equivalent, r.target), true, nil
+	}
+	return false, "", false, nil
+}
+
+// MergedPullRequests returns merge commits of upstream pull requests reachable
+// from revision, indexed by the pull request number.
+func MergedPullRequests(repository git.Git, revision string) (map[int]string, error) {
+	merges, err := repository.MergeSubjects(revision, "^Merge pull request #")
+	if err != nil {
+		return nil, fmt.Errorf("Error reading upstream merges: %w", err)
+	}
+	pullRequests := make(map[int]string)
+	for sha, subject := range merges {
+		match := mergePullRequestRE.FindStringSubmatch(subject)
+		if match == nil {
+			continue
+		}
+		number, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		pullRequests[number] = sha
+	}
+	return pullRequests, nil
+}
+
+// load indexes the upstream history, once.
+func (r *Resolver) load() error {
+	if r.loaded {
+		return nil
+	}
+	klog.V(2).Infof("Reading upstream pull requests merged into %s...", r.target)
+	pullRequests, err := MergedPullRequests(r.repository, r.target)
+	if err != nil {
+		return err
+	}
+	r.pullRequests = pullRequests
+	klog.V(2).Infof("Computing patch ids of upstream commits %s..%s...", r.from, r.target)
+	patchIDs, err := r.repository.PatchIDs(r.from + ".." + r.target)
+	if err != nil {
+		return fmt.Errorf("Error computing upstream patch ids: %w", err)
+	}
+	r.patchIDs = make(map[string]string, len(patchIDs))
+	for sha, patchID := range patchIDs {
+		r.patchIDs[patchID] = sha
+	}
+	r.loaded = true
+	return nil
+}
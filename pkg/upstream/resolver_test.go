@@ -0,0 +1,183 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/openshift/rebase/pkg/git"
+	"github.com/openshift/rebase/pkg/github"
+	"github.com/openshift/rebase/pkg/profile"
+)
+
+// fakeGit serves upstream merges and patch ids it holds, any other call panics.
+type fakeGit struct {
+	git.Git
+	// merges holds subjects of upstream merge commits, indexed by their sha
+	merges map[string]string
+	// patchIDs holds patch ids of upstream commits and picks, indexed by their sha
+	patchIDs map[string]string
+	// upstream lists shas of upstream commits between from and target
+	upstream []string
+	// ancestors lists shas which are ancestors of the target
+	ancestors []string
+}
+
+func (f *fakeGit) MergeSubjects(revision, grep string) (map[string]string, error) {
+	return f.merges, nil
+}
+
+func (f *fakeGit) PatchIDs(revisions ...string) (map[string]string, error) {
+	result := make(map[string]string)
+	if sha, ok := strings.CutSuffix(revisions[0], "^!"); ok {
+		result[sha] = f.patchIDs[sha]
+		return result, nil
+	}
+	for _, sha := range f.upstream {
+		result[sha] = f.patchIDs[sha]
+	}
+	return result, nil
+}
+
+func (f *fakeGit) IsAncestor(commit, revision string) (bool, error) {
+	for _, sha := range f.ancestors {
+		if sha == commit {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newRepository() *fakeGit {
+	return &fakeGit{
+		merges: map[string]string{
+			strings.Repeat("a", 40): "Merge pull request #101 from dev/fix",
+			strings.Repeat("b", 40): "Merge branch 'release-1.31'",
+		},
+		patchIDs: map[string]string{
+			strings.Repeat("c", 40): "patch-c",
+			strings.Repeat("1", 40): "patch-1",
+			strings.Repeat("2", 40): "patch-c",
+			strings.Repeat("3", 40): "patch-3",
+		},
+		upstream: []string{strings.Repeat("c", 40)},
+	}
+}
+
+func TestIsMerged(t *testing.T) {
+	tests := []struct {
+		name   string
+		sha    string
+		number int
+		// expected is whether the pick is skipped, reason is a part of the expected reason
+		expected bool
+		reason   string
+	}{
+		{
+			name:     "merged pull request",
+			sha:      strings.Repeat("1", 40),
+			number:   101,
+			expected: true,
+			reason:   "pull request #101 was merged in aaaaaaaaaaaa",
+		},
+		{
+			name:     "equivalent upstream commit",
+			sha:      strings.Repeat("2", 40),
+			number:   102,
+			expected: true,
+			reason:   "upstream commit cccccccccccc, which is part of",
+		},
+		{
+			name:   "pull request not found offline",
+			sha:    strings.Repeat("3", 40),
+			number: 103,
+			reason: "pull request #103 was not found in",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := NewResolver(newRepository(), &profile.Profile{}, nil, "v1.0.0", "v1.1.0")
+			merged, reason, err := resolver.IsMerged(test.sha, test.number)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if merged != test.expected {
+				t.Errorf("expected merged %v, got %v", test.expected, merged)
+			}
+			if !strings.Contains(reason, test.reason) {
+				t.Errorf("expected reason %q, got %q", test.reason, reason)
+			}
+		})
+	}
+}
+
+func TestIsMergedGitHub(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path.Base(r.URL.Path) {
+		case "104":
+			fmt.Fprintf(w, `{"number": 104, "merged": true, "merge_commit_sha": %q}`, strings.Repeat("d", 40))
+		case "105":
+			fmt.Fprintf(w, `{"number": 105, "merged": true, "merge_commit_sha": %q}`, strings.Repeat("e", 40))
+		case "106":
+			fmt.Fprint(w, `{"number": 106, "merged": false}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := github.NewClient(github.Options{BaseURL: server.URL + "/"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repository := newRepository()
+	repository.ancestors = []string{strings.Repeat("d", 40)}
+	upstream := profile.Repository{Owner: "kubernetes", Name: "kubernetes"}
+
+	tests := []struct {
+		name   string
+		number int
+		// expected is whether the pick is skipped, reason is a part of the expected reason
+		expected bool
+		reason   string
+	}{
+		{
+			name:     "merged before the target",
+			number:   104,
+			expected: true,
+			reason:   "pull request #104 was merged in dddddddddddd, which is part of",
+		},
+		{
+			name:   "merged after the target",
+			number: 105,
+			reason: "pull request #105 was merged in eeeeeeeeeeee, which is not part of",
+		},
+		{
+			name:   "not merged",
+			number: 106,
+			reason: "pull request #106 is not merged according to GitHub",
+		},
+		{
+			name:   "not found",
+			number: 107,
+			reason: "pull request #107 was not found in v1.1.0, and checking GitHub failed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := NewResolver(repository, &profile.Profile{Upstream: upstream}, client, "v1.0.0", "v1.1.0")
+			merged, reason, err := resolver.IsMerged(strings.Repeat("3", 40), test.number)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if merged != test.expected {
+				t.Errorf("expected merged %v, got %v", test.expected, merged)
+			}
+			if !strings.Contains(reason, test.reason) {
+				t.Errorf("expected reason %q, got %q", test.reason, reason)
+			}
+		})
+	}
+}